@@ -45,6 +45,7 @@ var programCommands = []app.ProgramCommand{
 				Arg: "[name]:[tag]", Description: "Image name and tag filter\nExamples:\n" +
 					"ls                  List all images\n" +
 					"ls my*              List images starting with 'my'\n" +
+					"ls team/*           List images in the namespace 'team'\n" +
 					"ls myimage:1.*      List layers of image 'myimage' with tags starting with '1.'\n" +
 					"ls :1.*             List layers of all images with tags starting with '1.'\n" +
 					"ls :                List layers of all images\n",
@@ -69,6 +70,7 @@ var programCommands = []app.ProgramCommand{
 				Arg: "[name]:[tag]", Description: "Image name and tag filter\nExamples:\n" +
 					"rm                  Delete all images\n" +
 					"rm my*              Delete all images starting with 'my'\n" +
+					"rm team/*           Delete all images in the namespace 'team'\n" +
 					"rm myimage:1.*      Delete image 'myimage' with tags starting with '1.'\n" +
 					"rm :1.*             Delete all images with tags starting with '1.'\n",
 			},
@@ -86,6 +88,142 @@ var programCommands = []app.ProgramCommand{
 			},
 		},
 	},
+	{
+		Run:         client.GarbageCollect,
+		Cmd:         "gc",
+		Description: "Delete unused manifests, blobs and stale uploads of all images",
+		Args: []app.ProgramCommandArg{
+			{
+				Arg: "-dry", Description: "Do NOT delete anything but show how many bytes could be reclaimed per image",
+			},
+			{
+				Arg: "-untagged", Description: "Delete manifests which are not reachable from a tag as well",
+			},
+			{
+				Arg: "-s host:port", Description: "Run the command on the given machine (optional)",
+			},
+			{
+				Arg: "-u username", Description: "Authenticate with the given username (optional)",
+			},
+			{
+				Arg: "-p password", Description: "Authenticate with the given password (optional)",
+			},
+		},
+	},
+	{
+		Run:         client.Retention,
+		Cmd:         "retention",
+		Description: "Delete the tags selected by the retention rules of the config",
+		Args: []app.ProgramCommandArg{
+			{
+				Arg: "-dry", Description: "Do NOT delete anything but show which tags would be deleted",
+			},
+			{
+				Arg: "-s host:port", Description: "Run the command on the given machine (optional)",
+			},
+			{
+				Arg: "-u username", Description: "Authenticate with the given username (optional)",
+			},
+			{
+				Arg: "-p password", Description: "Authenticate with the given password (optional)",
+			},
+		},
+	},
+	{
+		Run:         client.Verify,
+		Cmd:         "verify",
+		Description: "Verify the digests of manifests and blobs and report missing, corrupt and orphaned objects",
+		Args: []app.ProgramCommandArg{
+			{
+				Arg: "[name]:[tag]", Description: "Image name and tag filter\nExamples:\n" +
+					"verify              Verify all images and the blob store\n" +
+					"verify team/*       Verify the images in the namespace 'team'\n" +
+					"verify myimage:1.*  Verify the manifests and layers of image 'myimage' with tags starting with '1.'\n",
+			},
+			{
+				Arg: "-repair", Description: "Move corrupt blobs into the quarantine, the next push stores them again",
+			},
+			{
+				Arg: "-s host:port", Description: "Run the command on the given machine (optional)",
+			},
+			{
+				Arg: "-u username", Description: "Authenticate with the given username (optional)",
+			},
+			{
+				Arg: "-p password", Description: "Authenticate with the given password (optional)",
+			},
+		},
+	},
+	{
+		Run:         client.Export,
+		Cmd:         "export",
+		Description: "Export images as OCI image layout or docker archive tarball",
+		Args: []app.ProgramCommandArg{
+			{
+				Arg: "name:tag...", Description: "Images to export\nExamples:\n" +
+					"export myimage:1.0 -o bundle.tar              Export 'myimage:1.0' as OCI image layout\n" +
+					"export a:1.0 b:2.0 -o bundle.tar -format docker  Export 'a:1.0' and 'b:2.0' like docker save\n",
+			},
+			{
+				Arg: "-o file", Description: "Tarball to write",
+			},
+			{
+				Arg: "-format format", Description: "'oci' (default) or 'docker', docker archives cannot hold multi-arch images (optional)",
+			},
+			{
+				Arg: "-s host:port", Description: "Run the command on the given machine (optional)",
+			},
+			{
+				Arg: "-u username", Description: "Authenticate with the given username (optional)",
+			},
+			{
+				Arg: "-p password", Description: "Authenticate with the given password (optional)",
+			},
+		},
+	},
+	{
+		Run:         client.Import,
+		Cmd:         "import",
+		Description: "Import the images of an OCI image layout or docker archive tarball",
+		Args: []app.ProgramCommandArg{
+			{
+				Arg: "file", Description: "Tarball to import, e.g. written by mosi export or docker save",
+			},
+			{
+				Arg: "[name]", Description: "Image name of images the tarball has no name for (optional)",
+			},
+			{
+				Arg: "-s host:port", Description: "Run the command on the given machine (optional)",
+			},
+			{
+				Arg: "-u username", Description: "Authenticate with the given username (optional)",
+			},
+			{
+				Arg: "-p password", Description: "Authenticate with the given password (optional)",
+			},
+		},
+	},
+	{
+		Run:         client.Uploads,
+		Cmd:         "uploads",
+		Description: "List active, stale and recently removed blob uploads",
+		Args: []app.ProgramCommandArg{
+			{
+				Arg: "[name]", Description: "Image name filter\nExamples:\n" +
+					"uploads             List the uploads of all images\n" +
+					"uploads team/*      List the uploads of images in the namespace 'team'\n",
+			},
+			{
+				Arg: "-s host:port", Description: "Run the command on the given machine (optional)",
+			},
+			{
+				Arg: "-u username", Description: "Authenticate with the given username (optional)",
+			},
+			{
+				Arg: "-p password", Description: "Authenticate with the given password (optional)",
+			},
+		},
+	},
 }
 
 type program struct {
@@ -1,7 +1,10 @@
 package client
 
 import (
+	"archive/tar"
+	"errors"
 	"fmt"
+	"io"
 	"mosi-docker-registry/pkg/app"
 	"mosi-docker-registry/pkg/json"
 	"os"
@@ -104,6 +107,87 @@ func List(args []string) {
 	printTables(jsonObject)
 }
 
+func Uploads(args []string) {
+	client := create(&args, 0)
+	jsonObject := client.Get(makePath("/v2/cli/uploads/", args), nil)
+	printTables(jsonObject)
+}
+
+func Verify(args []string) {
+	client := create(&args, 0)
+	repair := app.BoolArg("-repair", false, &args)
+	app.CleanArgs(&args)
+	var jsonObject *json.JsonObject
+	if repair {
+		jsonObject = client.Delete(makePath("/v2/cli/verify/", args), nil)
+	} else {
+		jsonObject = client.Get(makePath("/v2/cli/verify/", args), nil)
+	}
+	printTables(jsonObject)
+}
+
+func GarbageCollect(args []string) {
+	client := create(&args, 0)
+	jsonArgs := json.NewJsonObject()
+	jsonArgs.Put("dry", app.BoolArg("-dry", false, &args))
+	jsonArgs.Put("untagged", app.BoolArg("-untagged", false, &args))
+	app.CleanArgs(&args)
+	jsonObject := client.Delete("/v2/cli/gc", jsonArgs)
+	printTables(jsonObject)
+}
+
+func Retention(args []string) {
+	client := create(&args, 0)
+	jsonArgs := json.NewJsonObject()
+	jsonArgs.Put("dry", app.BoolArg("-dry", false, &args))
+	app.CleanArgs(&args)
+	jsonObject := client.Delete("/v2/cli/retention", jsonArgs)
+	printTables(jsonObject)
+}
+
+func Export(args []string) {
+	client := create(&args, 1)
+	jsonArgs := json.NewJsonObject()
+	fn := app.StringArg("-o", "", &args)
+	jsonArgs.Put("format", app.StringArg("-format", "oci", &args))
+	app.CleanArgs(&args)
+	if fn == "" || len(args) == 0 {
+		handleError("missing image or output file, run with -h for help")
+	}
+	jsonArgs.Put("refs", json.JsonArrayFromStrings(args...))
+
+	client.Download("/v2/cli/export", jsonArgs, fn, checkArchive)
+}
+
+// Reads the tarball to its end, the tarball of an export which failed on the server is incomplete
+func checkArchive(fn string) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		_, err = tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("incomplete archive: %w", err)
+		}
+	}
+}
+
+func Import(args []string) {
+	client := create(&args, 1)
+	jsonArgs := json.NewJsonObject()
+	if len(args) > 1 {
+		jsonArgs.Put("name", args[1])
+	}
+	jsonObject := client.Upload("/v2/cli/import", jsonArgs, args[0])
+	printTables(jsonObject)
+}
+
 func Delete(args []string) {
 	client := create(&args, 0)
 	jsonArgs := json.NewJsonObject()
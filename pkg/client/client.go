@@ -226,6 +226,11 @@ func (c *mosiClient) do(req *http.Request) *http.Response {
 			// use basic auth
 			c.setBasicAuth(req)
 		}
+		// an upload sends its body again
+		if req.GetBody != nil {
+			req.Body, err = req.GetBody()
+			app.CheckError("", err)
+		}
 		rsp, err = c.client.Do(req)
 		app.CheckError("", err)
 	}
@@ -321,6 +326,67 @@ func (c *mosiClient) Get(path string, args *json.JsonObject) *json.JsonObject {
 	return result
 }
 
+// Writes the content of the response to the file fn, e.g. a tarball.
+// The file is replaced only if the whole content was received and check, if given, accepts it.
+func (c *mosiClient) Download(path string, args *json.JsonObject, fn string, check func(fn string) error) {
+	req := c.makeRequest("GET", path, args, nil)
+	rsp := c.do(req)
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != 200 {
+		app.CheckError("", errors.New(rsp.Status))
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(fn), filepath.Base(fn)+".*.tmp")
+	app.CheckError("Failed to create "+fn, err)
+	_, err = io.Copy(f, rsp.Body)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil && check != nil {
+		err = check(f.Name())
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), fn)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	app.CheckError("Failed to write "+fn, err)
+}
+
+// Posts the content of the file fn
+func (c *mosiClient) Upload(path string, args *json.JsonObject, fn string) *json.JsonObject {
+	f, err := os.Open(fn)
+	app.CheckError("Failed to open "+fn, err)
+	files := []*os.File{f}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	req := c.makeRequest("POST", path, args, f)
+	req.GetBody = func() (io.ReadCloser, error) {
+		f, err := os.Open(fn)
+		if err == nil {
+			files = append(files, f)
+		}
+		return f, err
+	}
+	rsp := c.do(req)
+
+	if rsp.StatusCode != 200 {
+		app.CheckError("", errors.New(rsp.Status))
+	}
+
+	result, err := c.jsonContent(rsp)
+	app.CheckError("Failed to read JSON content", err)
+
+	return result
+}
+
 func (c *mosiClient) Delete(path string, args *json.JsonObject) *json.JsonObject {
 	req := c.makeRequest("DELETE", path, args, nil)
 	rsp := c.do(req)
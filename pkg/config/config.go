@@ -13,6 +13,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const LOG = "Config"
@@ -45,21 +46,57 @@ type log struct {
 }
 
 type repo struct {
-	Dir                string `json:"dir"`
-	AllowAnonymousPull bool   `json:"allowAnonymousPull"`
+	Driver                   string          `json:"driver"`
+	Dir                      string          `json:"dir"`
+	AllowAnonymousPull       bool            `json:"allowAnonymousPull"`
+	UploadMaxIdleMinutes     int             `json:"uploadMaxIdleMinutes"`
+	BlobGracePeriodMinutes   int             `json:"blobGracePeriodMinutes"`
+	ManifestMaxSizeKiB       int             `json:"manifestMaxSizeKiB"`
+	ImportMaxSizeMiB         int             `json:"importMaxSizeMiB"`
+	S3                       s3              `json:"s3"`
+	Quotas                   []quota         `json:"quotas"`
+	Retention                []RetentionRule `json:"retention"`
+	RetentionIntervalMinutes int             `json:"retentionIntervalMinutes"`
+}
+
+type s3 struct {
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	Prefix    string `json:"prefix"`
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+}
+
+type quota struct {
+	Name       string `json:"name"`
+	MaxSizeMiB int    `json:"maxSizeMiB"`
+}
+
+// Selects the tags of the images matching Image and the tags matching Tag which get deleted:
+// all but the KeepLast most recently pushed ones and the ones older than MaxAgeDays, 0 disables a limit.
+// Tags matching Protect are always kept.
+type RetentionRule struct {
+	Image      string `json:"image"`
+	Tag        string `json:"tag"`
+	KeepLast   int    `json:"keepLast"`
+	MaxAgeDays int    `json:"maxAgeDays"`
+	Protect    string `json:"protect"`
 }
 
 type account struct {
-	Usr    string  `json:"usr"`
-	Pwd    string  `json:"pwd"`
-	Admin  bool    `json:"admin"`
-	Images []image `json:"images"`
+	Usr      string  `json:"usr"`
+	Pwd      string  `json:"pwd"`
+	Admin    bool    `json:"admin"`
+	QuotaMiB int     `json:"quotaMiB"`
+	Images   []image `json:"images"`
 }
 
 type image struct {
-	Name string `json:"name"`
-	Pull bool   `json:"pull"`
-	Push bool   `json:"push"`
+	Name   string `json:"name"`
+	Pull   bool   `json:"pull"`
+	Push   bool   `json:"push"`
+	Delete bool   `json:"delete"`
 }
 
 var cwd string
@@ -76,10 +113,104 @@ func RepoDir() string {
 	return makeAbs(cfg.Repo.Dir)
 }
 
+// "filesystem" stores the repository in RepoDir(), "s3" in an S3 bucket, "memory" keeps it in memory until the server stops
+func RepoDriver() string {
+	return cfg.Repo.Driver
+}
+
+// e.g. "https://s3.eu-central-1.amazonaws.com" or "http://minio:9000"
+func RepoS3Endpoint() string {
+	return cfg.Repo.S3.Endpoint
+}
+
+func RepoS3Region() string {
+	return cfg.Repo.S3.Region
+}
+
+func RepoS3Bucket() string {
+	return cfg.Repo.S3.Bucket
+}
+
+// Key prefix of the repository in the bucket, e.g. "mosi"
+func RepoS3Prefix() string {
+	return cfg.Repo.S3.Prefix
+}
+
+func RepoS3AccessKey() string {
+	return cfg.Repo.S3.AccessKey
+}
+
+func RepoS3SecretKey() string {
+	return cfg.Repo.S3.SecretKey
+}
+
 func AllowAnonymousPull() bool {
 	return cfg.Repo.AllowAnonymousPull
 }
 
+// Upload sessions which did not receive any data for longer get removed, 0 keeps them forever
+func UploadMaxIdle() time.Duration {
+	return time.Duration(cfg.Repo.UploadMaxIdleMinutes) * time.Minute
+}
+
+// Cleanups keep blobs which an image linked recently, e.g. the layers of a push whose manifest has not arrived yet
+func BlobGracePeriod() time.Duration {
+	return time.Duration(cfg.Repo.BlobGracePeriodMinutes) * time.Minute
+}
+
+// Larger manifests are rejected, 0 accepts manifests of any size
+func ManifestMaxSize() int64 {
+	return int64(cfg.Repo.ManifestMaxSizeKiB) * 1024
+}
+
+// Larger import archives are rejected, they are extracted into a temporary directory. 0 accepts archives of any size.
+func ImportMaxSize() int64 {
+	return int64(cfg.Repo.ImportMaxSizeMiB) * 1024 * 1024
+}
+
+// Returns the image patterns with a quota and their quotas in bytes, all images matching a pattern share its quota
+func RepoQuotas() map[string]int64 {
+	quotas := map[string]int64{}
+	for _, quota := range cfg.Repo.Quotas {
+		if quota.MaxSizeMiB > 0 {
+			quotas[quota.Name] = int64(quota.MaxSizeMiB) * 1024 * 1024
+		}
+	}
+	return quotas
+}
+
+// Returns the quota in bytes of the images the account may push to, 0 if the account has no quota
+func AccountQuota(usr string) (maxSize int64, imagesAllowedToPush []string) {
+	usr, _ = mapAndCheckAnonymousAccess(usr, true)
+	for _, account := range cfg.Accounts {
+		if account.Usr != usr {
+			continue
+		}
+		if account.QuotaMiB <= 0 {
+			return 0, nil
+		}
+		if account.Admin {
+			return int64(account.QuotaMiB) * 1024 * 1024, []string{"*"}
+		}
+		for _, image := range account.Images {
+			if image.Push {
+				imagesAllowedToPush = append(imagesAllowedToPush, image.Name)
+			}
+		}
+		return int64(account.QuotaMiB) * 1024 * 1024, imagesAllowedToPush
+	}
+	return 0, nil
+}
+
+func RetentionRules() []RetentionRule {
+	return cfg.Repo.Retention
+}
+
+// The server applies the retention rules periodically, 0 applies them only with the retention command
+func RetentionInterval() time.Duration {
+	return time.Duration(cfg.Repo.RetentionIntervalMinutes) * time.Minute
+}
+
 func ServerHost() string {
 	return cfg.Server.Host
 }
@@ -187,9 +318,10 @@ func LogLevelFile() int {
 	return logging.Level(cfg.Log.LogFileLevel)
 }
 
-func GetAccountImageAccessRights(usr, pwd string, allowAnonymous bool) (imagesAllowedToPull []string, imagesAllowedToPush []string) {
+func GetAccountImageAccessRights(usr, pwd string, allowAnonymous bool) (imagesAllowedToPull []string, imagesAllowedToPush []string, imagesAllowedToDelete []string) {
 	imagesAllowedToPull = nil
 	imagesAllowedToPush = nil
+	imagesAllowedToDelete = nil
 
 	account := getAccount(usr, pwd, allowAnonymous)
 	if account == nil {
@@ -203,13 +335,17 @@ func GetAccountImageAccessRights(usr, pwd string, allowAnonymous bool) (imagesAl
 		if image.Push || account.Admin {
 			imagesAllowedToPush = append(imagesAllowedToPush, image.Name)
 		}
+		if image.Delete || account.Admin {
+			imagesAllowedToDelete = append(imagesAllowedToDelete, image.Name)
+		}
 	}
 	return
 }
 
-func GetScopeImageAccessRights(imageName, usr, pwd string, allowAnonymous bool) (imagesAllowedToPull []string, imagesAllowedToPush []string) {
+func GetScopeImageAccessRights(imageName, usr, pwd string, allowAnonymous bool) (imagesAllowedToPull []string, imagesAllowedToPush []string, imagesAllowedToDelete []string) {
 	imagesAllowedToPull = nil
 	imagesAllowedToPush = nil
+	imagesAllowedToDelete = nil
 
 	account := getAccount(usr, pwd, allowAnonymous)
 	if account == nil {
@@ -224,6 +360,9 @@ func GetScopeImageAccessRights(imageName, usr, pwd string, allowAnonymous bool)
 			if image.Push || account.Admin {
 				imagesAllowedToPush = append(imagesAllowedToPush, imageName)
 			}
+			if image.Delete || account.Admin {
+				imagesAllowedToDelete = append(imagesAllowedToDelete, imageName)
+			}
 			return
 		}
 	}
@@ -269,8 +408,24 @@ func mapAndCheckAnonymousAccess(usr string, allowAnonymous bool) (string, bool)
 
 func initDefaults() {
 	cfg.Repo = repo{
-		Dir:                "repo",
-		AllowAnonymousPull: true,
+		Driver:                 "filesystem",
+		Dir:                    "repo",
+		AllowAnonymousPull:     true,
+		UploadMaxIdleMinutes:   1440,
+		BlobGracePeriodMinutes: 60,
+		ManifestMaxSizeKiB:     4096,
+		ImportMaxSizeMiB:       10240,
+		S3: s3{
+			Endpoint:  "",
+			Region:    "us-east-1",
+			Bucket:    "",
+			Prefix:    "",
+			AccessKey: "",
+			SecretKey: "",
+		},
+		Quotas:                   []quota{},
+		Retention:                []RetentionRule{},
+		RetentionIntervalMinutes: 60,
 	}
 
 	cfg.Server = server{
@@ -299,9 +454,10 @@ func initDefaults() {
 			Admin: true,
 			Images: []image{
 				{
-					Name: "*",
-					Pull: true,
-					Push: true,
+					Name:   "*",
+					Pull:   true,
+					Push:   true,
+					Delete: true,
 				},
 			},
 		},
@@ -311,9 +467,10 @@ func initDefaults() {
 			Admin: false,
 			Images: []image{
 				{
-					Name: "*",
-					Pull: true,
-					Push: false,
+					Name:   "*",
+					Pull:   true,
+					Push:   false,
+					Delete: false,
 				},
 			},
 		},
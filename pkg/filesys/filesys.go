@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -21,6 +22,11 @@ func Exists(fn string) bool {
 	return err == nil
 }
 
+func IsDir(fn string) bool {
+	fileInfo, err := os.Stat(fn)
+	return err == nil && fileInfo.IsDir()
+}
+
 func Size(fn string) (int64, error) {
 	fileInfo, err := os.Stat(fn)
 	if err != nil {
@@ -29,6 +35,14 @@ func Size(fn string) (int64, error) {
 	return fileInfo.Size(), nil
 }
 
+func Modified(fn string) (time.Time, error) {
+	fileInfo, err := os.Stat(fn)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fileInfo.ModTime(), nil
+}
+
 // "Tue, 29 Nov 2022 14:56:29 GMT"
 func ModifiedHttpDate(fn string) (string, error) {
 	fileInfo, err := os.Stat(fn)
@@ -107,6 +121,41 @@ func CopyOrDelete(src io.Reader, dst string) (int64, error) {
 	return written, nil
 }
 
+// Appends src to the existing file dst, on failure dst is truncated back to its original size
+func AppendOrTruncate(src io.Reader, dst string) (int64, error) {
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return -1, err
+	}
+
+	fileInfo, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return -1, err
+	}
+	size := fileInfo.Size()
+
+	written, err := io.Copy(f, src)
+
+	if err != nil {
+		f.Truncate(size)
+		f.Close()
+		return -1, err
+	}
+
+	err = f.Close()
+
+	if err != nil {
+		os.Truncate(dst, size)
+		return -1, err
+	}
+	return written, nil
+}
+
+func Truncate(fn string, size int64) error {
+	return os.Truncate(fn, size)
+}
+
 func Copy(fn string, w io.Writer) (int64, error) {
 	f, err := os.Open(fn)
 	if err != nil {
@@ -116,6 +165,21 @@ func Copy(fn string, w io.Writer) (int64, error) {
 	return io.Copy(w, f)
 }
 
+// Serves a file with support for range and conditional requests, the caller sets Content-Type and ETag
+func ServeContent(fn string, w http.ResponseWriter, r *http.Request) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fileInfo, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	http.ServeContent(w, r, "", fileInfo.ModTime(), f)
+	return nil
+}
+
 func DeleteFile(fn string) error {
 	return os.Remove(fn)
 }
@@ -124,6 +188,29 @@ func DeleteDir(dir string) error {
 	return os.RemoveAll(dir)
 }
 
+// Deletes dir and its parent directories as long as they are empty, stops at but never deletes stopDir
+func DeleteEmptyDirs(dir, stopDir string) error {
+	for strings.HasPrefix(dir, stopDir+string(filepath.Separator)) {
+		fns, err := GetAllFilenamesInDir(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			dir = filepath.Dir(dir)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if len(fns) > 0 {
+			return nil
+		}
+		err = os.Remove(dir)
+		if err != nil {
+			return err
+		}
+		dir = filepath.Dir(dir)
+	}
+	return nil
+}
+
 func RenameOrDelete(src, dst string) error {
 	dir := filepath.Dir(dst)
 	err := CreateDir(dir)
@@ -206,49 +293,6 @@ func GetAllFilenamesInDir(dir string) ([]string, error) {
 	return fns, nil
 }
 
-// depth-first
-func FindFile(dir, fn string) (string, error) {
-	f, err := os.Open(dir)
-	if err != nil {
-		return "", err
-	}
-	fns, err := f.Readdirnames(-1)
-	f.Close()
-	if err != nil {
-		return "", nil
-	}
-	for _, child := range fns {
-		joined := filepath.Join(dir, child)
-		var stat fs.FileInfo = nil
-		isDir := true
-		if child == fn {
-			stat, err = os.Stat(joined)
-			if err != nil {
-				return "", err
-			}
-			isDir = stat.IsDir()
-			if !isDir {
-				return joined, nil
-			}
-		}
-		// Note that if stat != nil then isDir is already valid
-		if stat == nil {
-			stat, err = os.Stat(joined)
-			if err != nil {
-				return "", err
-			}
-			isDir = stat.IsDir()
-		}
-		if isDir {
-			sub, err := FindFile(joined, fn)
-			if sub != "" {
-				return sub, err
-			}
-		}
-	}
-	return "", nil
-}
-
 func CreateDigestFromFile(fn string) (string, error) {
 	f, err := os.Open(fn)
 	if err != nil {
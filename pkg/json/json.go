@@ -41,6 +41,17 @@ func DecodeReader(reader io.Reader) (*JsonObject, error) {
 	return jsonObjectFromMap(&mm), nil
 }
 
+// Decodes a top level array, e.g. the manifest.json of a docker archive
+func DecodeArrayBytes(b []byte) (*JsonArray, error) {
+	a := []interface{}{}
+	err := json.NewDecoder(bytes.NewReader(b)).Decode(&a)
+	if err != nil {
+		return nil, err
+	}
+
+	return jsonArrayFromArray(&a), nil
+}
+
 func jsonObjectFromMap(m *map[string]interface{}) *JsonObject {
 	jsonObject := NewJsonObject()
 
@@ -254,6 +265,10 @@ func (a *JsonArray) MarshalJSON() ([]byte, error) {
 	return json.Marshal(a.array)
 }
 
+func (a *JsonArray) EncodeBytes() ([]byte, error) {
+	return json.Marshal(a.array)
+}
+
 func (a *JsonArray) Len() int {
 	return len(a.array)
 }
@@ -168,3 +168,21 @@ func TestEncDec(t *testing.T) {
 	assert.Equal(true, json2.GetArrayUnsafe("array").GetArrayUnsafe(3).GetBoolUnsafe(2))
 	assert.Equal("val", json2.GetArrayUnsafe("array").GetArrayUnsafe(3).GetObjectUnsafe(3).GetStringUnsafe("key"))
 }
+
+func TestArrayEncDec(t *testing.T) {
+	assert := assert.New(t)
+
+	a1 := NewJsonArray(0).Add(NewJsonObject().Put("Config", "config.json")).Add("element1")
+	b, err := a1.EncodeBytes()
+	assert.Nil(err)
+	assert.Equal(`[{"Config":"config.json"},"element1"]`, string(b))
+
+	a2, err := DecodeArrayBytes(b)
+	assert.Nil(err)
+	assert.Equal(2, a2.Len())
+	assert.Equal("config.json", a2.GetObjectUnsafe(0).GetStringUnsafe("Config"))
+	assert.Equal("element1", a2.GetStringUnsafe(1))
+
+	_, err = DecodeArrayBytes([]byte(`{"key":"val"}`))
+	assert.NotNil(err)
+}
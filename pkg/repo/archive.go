@@ -0,0 +1,822 @@
+package repo
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mosi-docker-registry/pkg/config"
+	"mosi-docker-registry/pkg/filesys"
+	"mosi-docker-registry/pkg/json"
+	"mosi-docker-registry/pkg/logging"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Images are moved between registries without a docker daemon as tarballs of one of two formats:
+//
+// An OCI image layout has the files oci-layout, index.json with the tagged manifests
+// and blobs/sha256/hex with the manifests, configs and layers.
+//
+// A docker archive as written by docker save has manifest.json listing the config file, the layer files
+// and the tags of each image. Docker archives hold image manifests only, no manifest lists or image indexes.
+
+const (
+	ArchiveFormatOci    = "oci"
+	ArchiveFormatDocker = "docker"
+)
+
+const (
+	mediaTypeDockerConfig    = "application/vnd.docker.container.image.v1+json"
+	mediaTypeDockerLayer     = "application/vnd.docker.image.rootfs.diff.tar"
+	mediaTypeDockerLayerGzip = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+
+	annotationRefName       = "org.opencontainers.image.ref.name"
+	annotationContainerName = "io.containerd.image.name"
+)
+
+type exportImage struct {
+	img          string
+	tag          string
+	digest       string
+	manifestJson *json.JsonObject
+}
+
+type exportBlob struct {
+	digest  string
+	storeFn string
+	size    int64
+}
+
+// The images and the content of an export, collected before anything is written
+type ExportArchive struct {
+	format    string
+	images    []exportImage
+	manifests map[string][]byte
+	// manifests and blobs in the order they are written
+	manifestDigests []string
+	blobs           []exportBlob
+	blobDigests     map[string]bool
+}
+
+// Collects the manifests and blobs of the images, refs are name:tag or name@digest
+func PrepareExport(refs []string, format string) (*ExportArchive, error) {
+	if format == "" {
+		format = ArchiveFormatOci
+	}
+	if format != ArchiveFormatOci && format != ArchiveFormatDocker {
+		return nil, fmt.Errorf("unknown archive format %s, supported are %s and %s", format, ArchiveFormatOci, ArchiveFormatDocker)
+	}
+	if len(refs) == 0 {
+		return nil, fmt.Errorf("%w: no image to export", ErrNameUnknown)
+	}
+
+	a := &ExportArchive{format: format, manifests: map[string][]byte{}, blobDigests: map[string]bool{}}
+	for _, ref := range refs {
+		img, reference := parseImageRef(ref)
+		err := checkImageRef(img, reference)
+		if err != nil {
+			return nil, err
+		}
+		digest, err := resolveManifest(img, reference)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrManifestUnknown, ref)
+		}
+		if err != nil {
+			return nil, err
+		}
+		manifestJson, err := a.addManifest(img, digest)
+		if err != nil {
+			return nil, err
+		}
+		if format == ArchiveFormatDocker && isIndexManifest(manifestJson) {
+			return nil, fmt.Errorf("%w: %s is a manifest list or image index, export it as %s", ErrManifestNotAccepted, ref, ArchiveFormatOci)
+		}
+		tag := ""
+		if !isDigestReference(reference) {
+			tag = reference
+		}
+		a.images = append(a.images, exportImage{img: img, tag: tag, digest: digest, manifestJson: manifestJson})
+	}
+	return a, nil
+}
+
+// Adds a manifest, the manifests of an image index and the blobs they reference
+func (a *ExportArchive) addManifest(img, digest string) (*json.JsonObject, error) {
+	fn := getManifestRevisionFilename(img, digest)
+	content, err := readBytes(fn)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s@%s", ErrManifestUnknown, img, digest)
+	}
+	if err != nil {
+		return nil, err
+	}
+	manifestJson, err := json.DecodeBytes(content)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s@%s", ErrManifestInvalid, img, digest)
+	}
+	if _, ok := a.manifests[digest]; ok {
+		return manifestJson, nil
+	}
+
+	if isIndexManifest(manifestJson) {
+		childDigests, err := getIndexManifestDigests(manifestJson)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrManifestInvalid, err.Error())
+		}
+		for _, childDigest := range childDigests {
+			_, err = a.addManifest(img, childDigest)
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		blobDigests, err := getManifestBlobDigests(manifestJson)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrManifestInvalid, err.Error())
+		}
+		for _, blobDigest := range blobDigests {
+			if a.blobDigests[blobDigest] {
+				continue
+			}
+			storeFn, err := getLinkedBlobFilename(img, blobDigest)
+			if err != nil {
+				return nil, err
+			}
+			blobSize, err := size(storeFn)
+			if err != nil {
+				return nil, err
+			}
+			a.blobDigests[blobDigest] = true
+			a.blobs = append(a.blobs, exportBlob{digest: blobDigest, storeFn: storeFn, size: blobSize})
+		}
+	}
+
+	a.manifests[digest] = content
+	a.manifestDigests = append(a.manifestDigests, digest)
+	return manifestJson, nil
+}
+
+// Writes the archive as tarball
+func (a *ExportArchive) Write(w io.Writer) error {
+	tw := tar.NewWriter(w)
+	var err error
+	if a.format == ArchiveFormatDocker {
+		err = a.writeDocker(tw)
+	} else {
+		err = a.writeOci(tw)
+	}
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func (a *ExportArchive) writeOci(tw *tar.Writer) error {
+	err := writeTarBytes(tw, "oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`))
+	if err != nil {
+		return err
+	}
+	for _, blob := range a.blobs {
+		err = writeTarBlob(tw, ociBlobPath(blob.digest), blob)
+		if err != nil {
+			return err
+		}
+	}
+	for _, digest := range a.manifestDigests {
+		err = writeTarBytes(tw, ociBlobPath(digest), a.manifests[digest])
+		if err != nil {
+			return err
+		}
+	}
+
+	manifests := json.NewJsonArray(0)
+	for _, image := range a.images {
+		annotations := json.NewJsonObject()
+		if image.tag != "" {
+			annotations.Put(annotationRefName, image.tag)
+			annotations.Put(annotationContainerName, image.img+":"+image.tag)
+		} else {
+			annotations.Put(annotationContainerName, image.img)
+		}
+		descriptor := json.NewJsonObject()
+		descriptor.Put("mediaType", getManifestMediaType(image.manifestJson))
+		descriptor.Put("digest", image.digest)
+		descriptor.Put("size", len(a.manifests[image.digest]))
+		descriptor.Put("annotations", annotations)
+		manifests.Add(descriptor)
+	}
+	index := json.NewJsonObject()
+	index.Put("schemaVersion", 2)
+	index.Put("mediaType", MediaTypeOciIndex)
+	index.Put("manifests", manifests)
+	content, err := index.EncodeBytes()
+	if err != nil {
+		return err
+	}
+	return writeTarBytes(tw, "index.json", content)
+}
+
+func (a *ExportArchive) writeDocker(tw *tar.Writer) error {
+	for _, blob := range a.blobs {
+		err := writeTarBlob(tw, dockerBlobPath(blob.digest, a.isConfig(blob.digest)), blob)
+		if err != nil {
+			return err
+		}
+	}
+
+	entries := json.NewJsonArray(0)
+	for _, image := range a.images {
+		configDigest, err := getManifestConfigDigest(image.manifestJson)
+		if err != nil {
+			return err
+		}
+		layerDigests, err := getManifestLayerDigests(image.manifestJson)
+		if err != nil {
+			return err
+		}
+		layers := json.NewJsonArray(0)
+		for _, layerDigest := range layerDigests {
+			layers.Add(dockerBlobPath(layerDigest, false))
+		}
+		repoTags := json.NewJsonArray(0)
+		if image.tag != "" {
+			repoTags.Add(image.img + ":" + image.tag)
+		}
+		entry := json.NewJsonObject()
+		entry.Put("Config", dockerBlobPath(configDigest, true))
+		entry.Put("RepoTags", repoTags)
+		entry.Put("Layers", layers)
+		entries.Add(entry)
+	}
+	content, err := entries.EncodeBytes()
+	if err != nil {
+		return err
+	}
+	return writeTarBytes(tw, "manifest.json", content)
+}
+
+func (a *ExportArchive) isConfig(digest string) bool {
+	for _, image := range a.images {
+		if configDigest, err := getManifestConfigDigest(image.manifestJson); err == nil && configDigest == digest {
+			return true
+		}
+	}
+	return false
+}
+
+// blobs/sha256/hex
+func ociBlobPath(digest string) string {
+	return "blobs/" + strings.Replace(digest, ":", "/", 1)
+}
+
+// hex.json for configs, hex/layer.tar for layers
+func dockerBlobPath(digest string, isConfig bool) string {
+	encoded := digest[strings.Index(digest, ":")+1:]
+	if isConfig {
+		return encoded + ".json"
+	}
+	return encoded + "/layer.tar"
+}
+
+func writeTarBytes(tw *tar.Writer, name string, content []byte) error {
+	err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), ModTime: time.Unix(0, 0)})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(content)
+	return err
+}
+
+func writeTarBlob(tw *tar.Writer, name string, blob exportBlob) error {
+	r, err := store().Reader(blob.storeFn)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: blob.size, ModTime: time.Unix(0, 0)})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, r)
+	return err
+}
+
+// Imports the images of an OCI image layout or docker archive tarball after verifying the digests of its content.
+// name is the image of manifests the archive has no name for, the blobs count against the quotas of the image and of the account usr.
+func Import(r io.Reader, name, usr string) (*json.JsonObject, error) {
+	if name != "" {
+		img, _ := parseImageRef(normalizeImageName(name))
+		if !IsValidImageName(img) {
+			return nil, fmt.Errorf("%w: %s", ErrNameInvalid, name)
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "mosi-import-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	a := &importArchive{dir: dir, links: map[string]string{}, name: name, usr: usr, rows: json.NewJsonArray(0)}
+	err = a.extract(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = os.Stat(a.path("index.json")); err == nil {
+		err = a.importOci()
+	} else if _, err = os.Stat(a.path("manifest.json")); err == nil {
+		err = a.importDocker()
+	} else {
+		err = fmt.Errorf("%w: neither an OCI image layout nor a docker archive", ErrManifestInvalid)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tables := json.NewJsonArray(0)
+	res := json.NewJsonObject()
+	res.Put("tables", tables)
+	table := json.NewJsonObject()
+	table.Put("fields", json.JsonArrayFromStrings("Image", "Tag", "Digest", "Blobs"))
+	table.Put("rows", a.rows)
+	tables.Add(table)
+
+	logging.Info(LOG, "imported %d images", a.rows.Len())
+	return res, nil
+}
+
+type importArchive struct {
+	dir string
+	// symbolic links of the archive, docker save links layers shared by several images
+	links map[string]string
+	name  string
+	usr   string
+	rows  *json.JsonArray
+}
+
+// A manifest of index.json and the image and reference it gets imported as
+type importOciImage struct {
+	img       string
+	reference string
+	digest    string
+}
+
+// Extracts the regular files of the archive into the temporary directory, up to config.ImportMaxSize() bytes
+func (a *importArchive) extract(r io.Reader) error {
+	maxSize := config.ImportMaxSize()
+	var extracted int64 = 0
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrManifestInvalid, err.Error())
+		}
+		name := path.Clean(strings.TrimPrefix(header.Name, "./"))
+		if name == ".." || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+			return fmt.Errorf("%w: invalid path %s", ErrManifestInvalid, header.Name)
+		}
+		switch header.Typeflag {
+		case tar.TypeReg:
+			extracted += header.Size
+			if maxSize > 0 && extracted > maxSize {
+				return fmt.Errorf("%w, the archive exceeds %s", ErrSizeInvalid, filesys.Bytes2IEC(maxSize))
+			}
+			fn := a.path(name)
+			err = os.MkdirAll(filepath.Dir(fn), 0700)
+			if err != nil {
+				return err
+			}
+			f, err := os.Create(fn)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("%w: %s", ErrManifestInvalid, err.Error())
+			}
+		case tar.TypeSymlink, tar.TypeLink:
+			target := header.Linkname
+			if header.Typeflag == tar.TypeSymlink {
+				target = path.Join(path.Dir(name), header.Linkname)
+			}
+			a.links[name] = path.Clean(target)
+		}
+	}
+}
+
+// Returns the file of a path of the archive, following links
+func (a *importArchive) path(name string) string {
+	for i := 0; i < 8; i++ {
+		target, ok := a.links[path.Clean(name)]
+		if !ok {
+			break
+		}
+		name = target
+	}
+	if name == ".." || strings.HasPrefix(name, "../") {
+		name = "invalid"
+	}
+	return filepath.Join(a.dir, filepath.FromSlash(name))
+}
+
+func (a *importArchive) readJson(name string) (*json.JsonObject, []byte, error) {
+	content, err := os.ReadFile(a.path(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, fmt.Errorf("%w: %s is missing", ErrManifestInvalid, name)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	jsonObject, err := json.DecodeBytes(content)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s: %s", ErrManifestInvalid, name, err.Error())
+	}
+	return jsonObject, content, nil
+}
+
+func (a *importArchive) importOci() error {
+	index, _, err := a.readJson("index.json")
+	if err != nil {
+		return err
+	}
+	manifests, err := getIndexManifests(index)
+	if err != nil {
+		return fmt.Errorf("%w: index.json: %s", ErrManifestInvalid, err.Error())
+	}
+	// the names of all images are checked before anything is written
+	images := make([]importOciImage, 0, manifests.Len())
+	for i := 0; i < manifests.Len(); i++ {
+		descriptor := manifests.GetObject(i, json.NewJsonObject())
+		digest := descriptor.GetString("digest", "")
+		annotations := descriptor.GetObject("annotations", json.NewJsonObject())
+
+		img, reference := a.name, digest
+		if containerName := annotations.GetString(annotationContainerName, ""); containerName != "" {
+			img, reference = parseImageRef(normalizeImageName(containerName))
+		} else if refName := annotations.GetString(annotationRefName, ""); strings.ContainsAny(refName, ":/") {
+			img, reference = parseImageRef(normalizeImageName(refName))
+		}
+		if refName := annotations.GetString(annotationRefName, ""); isValidTag(refName) {
+			reference = refName
+		}
+		if img == "" {
+			return fmt.Errorf("%w: no image name for %s, import it with a name", ErrNameUnknown, digest)
+		}
+		err = checkImageRef(img, reference)
+		if err != nil {
+			return err
+		}
+		images = append(images, importOciImage{img: img, reference: reference, digest: digest})
+	}
+
+	for _, image := range images {
+		nBlobs, err := a.importOciManifest(image.img, image.digest)
+		if err != nil {
+			return err
+		}
+		content, err := a.readOciBlob(image.digest)
+		if err != nil {
+			return err
+		}
+		_, err = a.uploadManifest(image.img, image.reference, content)
+		if err != nil {
+			return err
+		}
+		a.addRow(image.img, image.reference, image.digest, nBlobs)
+	}
+	return nil
+}
+
+// Imports the blobs and child manifests of a manifest, the manifest itself is uploaded by the caller
+func (a *importArchive) importOciManifest(img, digest string) (int, error) {
+	content, err := a.readOciBlob(digest)
+	if err != nil {
+		return 0, err
+	}
+	manifestJson, err := json.DecodeBytes(content)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrManifestInvalid, digest)
+	}
+
+	nBlobs := 0
+	if isIndexManifest(manifestJson) {
+		childDigests, err := getIndexManifestDigests(manifestJson)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s", ErrManifestInvalid, err.Error())
+		}
+		for _, childDigest := range childDigests {
+			n, err := a.importOciManifest(img, childDigest)
+			if err != nil {
+				return 0, err
+			}
+			childContent, err := a.readOciBlob(childDigest)
+			if err != nil {
+				return 0, err
+			}
+			_, err = a.uploadManifest(img, childDigest, childContent)
+			if err != nil {
+				return 0, err
+			}
+			nBlobs += n
+		}
+		return nBlobs, nil
+	}
+
+	blobDigests, err := getManifestBlobDigests(manifestJson)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrManifestInvalid, err.Error())
+	}
+	for _, blobDigest := range blobDigests {
+		fn := a.path(ociBlobPath(blobDigest))
+		err = verifyFileDigest(fn, blobDigest)
+		if err != nil {
+			return 0, err
+		}
+		err = a.importBlob(img, blobDigest, fn)
+		if err != nil {
+			return 0, err
+		}
+		nBlobs++
+	}
+	return nBlobs, nil
+}
+
+func (a *importArchive) readOciBlob(digest string) ([]byte, error) {
+	fn := a.path(ociBlobPath(digest))
+	err := verifyFileDigest(fn, digest)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(fn)
+}
+
+func (a *importArchive) importDocker() error {
+	content, err := os.ReadFile(a.path("manifest.json"))
+	if err != nil {
+		return err
+	}
+	entries, err := json.DecodeArrayBytes(content)
+	if err != nil {
+		return fmt.Errorf("%w: manifest.json: %s", ErrManifestInvalid, err.Error())
+	}
+
+	// the names of all images are checked before anything is written
+	entryRefs := make([][]string, entries.Len())
+	for i := 0; i < entries.Len(); i++ {
+		entry := entries.GetObject(i, json.NewJsonObject())
+		refs := entry.GetArray("RepoTags", json.NewJsonArray(0)).ToStringArray("")
+		if len(refs) == 0 {
+			if a.name == "" {
+				return fmt.Errorf("%w: no tag for %s, import it with a name", ErrNameUnknown, entry.GetString("Config", ""))
+			}
+			refs = []string{a.name}
+		}
+		for _, ref := range refs {
+			err = checkImageRef(parseImageRef(normalizeImageName(ref)))
+			if err != nil {
+				return err
+			}
+		}
+		entryRefs[i] = refs
+	}
+
+	for i := 0; i < entries.Len(); i++ {
+		entry := entries.GetObject(i, json.NewJsonObject())
+		manifestJson, blobFns, err := a.createDockerManifest(entry)
+		if err != nil {
+			return err
+		}
+		manifestContent, err := manifestJson.EncodeBytes()
+		if err != nil {
+			return err
+		}
+
+		for _, ref := range entryRefs[i] {
+			img, tag := parseImageRef(normalizeImageName(ref))
+			for blobDigest, fn := range blobFns {
+				err = a.importBlob(img, blobDigest, fn)
+				if err != nil {
+					return err
+				}
+			}
+			digest, err := a.uploadManifest(img, tag, manifestContent)
+			if err != nil {
+				return err
+			}
+			a.addRow(img, tag, digest, len(blobFns))
+		}
+	}
+	return nil
+}
+
+// Creates the docker image manifest of an entry of manifest.json, verifying the config and the layers' diff ids
+func (a *importArchive) createDockerManifest(entry *json.JsonObject) (*json.JsonObject, map[string]string, error) {
+	configName := entry.GetString("Config", "")
+	configJson, configContent, err := a.readJson(configName)
+	if err != nil {
+		return nil, nil, err
+	}
+	configDigest, err := filesys.CreateDigestFromBuffer(configContent)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the config's file name is its digest, e.g. hex.json or blobs/sha256/hex
+	if encoded := strings.TrimSuffix(path.Base(configName), ".json"); len(encoded) == 64 && "sha256:"+encoded != configDigest {
+		return nil, nil, fmt.Errorf("%w, expected: sha256:%s got: %s", ErrDigestInvalid, encoded, configDigest)
+	}
+
+	blobFns := map[string]string{configDigest: a.path(configName)}
+	diffIds := configJson.GetObject("rootfs", json.NewJsonObject()).GetArray("diff_ids", json.NewJsonArray(0)).ToStringArray("")
+	layerNames := entry.GetArray("Layers", json.NewJsonArray(0)).ToStringArray("")
+	if len(diffIds) != len(layerNames) {
+		return nil, nil, fmt.Errorf("%w: %s has %d diff ids for %d layers", ErrManifestInvalid, configName, len(diffIds), len(layerNames))
+	}
+
+	layers := json.NewJsonArray(0)
+	for i, layerName := range layerNames {
+		fn := a.path(layerName)
+		digest, diffId, compressed, err := getLayerDigests(fn)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, fmt.Errorf("%w: %s is missing", ErrManifestInvalid, layerName)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if diffId != diffIds[i] {
+			return nil, nil, fmt.Errorf("%w, layer %s expected: %s got: %s", ErrDigestInvalid, layerName, diffIds[i], diffId)
+		}
+		fileInfo, err := os.Stat(fn)
+		if err != nil {
+			return nil, nil, err
+		}
+		mediaType := mediaTypeDockerLayer
+		if compressed {
+			mediaType = mediaTypeDockerLayerGzip
+		}
+		layer := json.NewJsonObject()
+		layer.Put("mediaType", mediaType)
+		layer.Put("size", fileInfo.Size())
+		layer.Put("digest", digest)
+		layers.Add(layer)
+		blobFns[digest] = fn
+	}
+
+	configDescriptor := json.NewJsonObject()
+	configDescriptor.Put("mediaType", mediaTypeDockerConfig)
+	configDescriptor.Put("size", len(configContent))
+	configDescriptor.Put("digest", configDigest)
+	manifestJson := json.NewJsonObject()
+	manifestJson.Put("schemaVersion", 2)
+	manifestJson.Put("mediaType", MediaTypeDockerManifest)
+	manifestJson.Put("config", configDescriptor)
+	manifestJson.Put("layers", layers)
+	return manifestJson, blobFns, nil
+}
+
+func (a *importArchive) uploadManifest(img, reference string, content []byte) (string, error) {
+	digest, _, _, _, err := UploadManifest(img, reference, io.NopCloser(bytes.NewReader(content)))
+	return digest, err
+}
+
+func (a *importArchive) addRow(img, reference, digest string, nBlobs int) {
+	tag := reference
+	if isDigestReference(reference) {
+		tag = "-"
+	}
+	a.rows.Add(json.JsonArrayFromAny(img, tag, digest, nBlobs))
+}
+
+// Stores a verified blob and links it into the image unless the image has it already
+func (a *importArchive) importBlob(img, digest, fn string) error {
+	if _, err := getLinkedBlobFilename(img, digest); err == nil {
+		return nil
+	}
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// an import is denied like a push if it exceeds a quota of the image or of the account
+	reader, err := QuotaReader(img, a.usr, f)
+	if err != nil {
+		return err
+	}
+
+	uploadUuid, err := CreateBlobUpload(img)
+	if err != nil {
+		return err
+	}
+	uploadFn, err := getBlobUploadFilename(img, uploadUuid)
+	if err != nil {
+		return err
+	}
+	_, err = UploadBlobChunk(img, uploadUuid, -1, -1, reader)
+	if err != nil {
+		deleteFile(uploadFn)
+		forgetImageUsage(img)
+		return err
+	}
+	return storeBlob(img, digest, uploadFn)
+}
+
+// Only sha256 digests are supported
+func verifyFileDigest(fn, digest string) error {
+	if !isSha256(digest) {
+		return fmt.Errorf("%w: unsupported digest %s", ErrDigestInvalid, digest)
+	}
+	fileDigest, err := filesys.CreateDigestFromFile(fn)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s is missing", ErrManifestBlobUnknown, digest)
+	}
+	if err != nil {
+		return err
+	}
+	if fileDigest != digest {
+		return fmt.Errorf("%w, expected: %s got: %s", ErrDigestInvalid, digest, fileDigest)
+	}
+	return nil
+}
+
+// Returns the digest of a layer file and the digest of its uncompressed content, the diff id
+func getLayerDigests(fn string) (digest string, diffId string, compressed bool, err error) {
+	digest, err = filesys.CreateDigestFromFile(fn)
+	if err != nil {
+		return
+	}
+	f, err := os.Open(fn)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	compressed, err = isGzipReader(f)
+	if err != nil || !compressed {
+		diffId = digest
+		return
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return
+	}
+	sha := sha256.New()
+	_, err = io.Copy(sha, gz)
+	if err != nil {
+		return
+	}
+	diffId = "sha256:" + hex.EncodeToString(sha.Sum(nil))
+	return
+}
+
+// name:tag or name@digest, the tag defaults to latest
+func parseImageRef(ref string) (img, reference string) {
+	if i := strings.Index(ref, "@"); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, "latest"
+}
+
+// Checks the image name and the reference of an archive or request like a push does,
+// e.g. a name of a crafted archive must not point outside of the repository
+func checkImageRef(img, reference string) error {
+	if !IsValidImageName(img) {
+		return fmt.Errorf("%w: %s", ErrNameInvalid, img)
+	}
+	err := checkReference(reference)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, reference)
+	}
+	return nil
+}
+
+// Removes the registry host of a name, e.g. docker.io/library/nginx:1.25 becomes nginx:1.25
+func normalizeImageName(name string) string {
+	i := strings.Index(name, "/")
+	if i < 0 {
+		return name
+	}
+	host := name[:i]
+	if !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return name
+	}
+	name = name[i+1:]
+	if host == "docker.io" || host == "index.docker.io" {
+		name = strings.TrimPrefix(name, "library/")
+	}
+	return name
+}
@@ -0,0 +1,173 @@
+package repo
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mosi-docker-registry/pkg/filesys"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportImport(t *testing.T) {
+	assert := assert.New(t)
+
+	useTestConfig(t, "")
+
+	uncompressed := []byte("layer content")
+	diffId, _ := filesys.CreateDigestFromBuffer(uncompressed)
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(uncompressed)
+	gw.Close()
+	layerDigest := pushTestBlob(t, "team/app", gz.Bytes())
+	configContent := []byte(fmt.Sprintf(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":["%s"]}}`, diffId))
+	configDigest := pushTestBlob(t, "team/app", configContent)
+	manifest := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"%s","config":{"mediaType":"%s","size":%d,"digest":"%s"},"layers":[{"mediaType":"%s","size":%d,"digest":"%s"}]}`,
+		MediaTypeDockerManifest, mediaTypeDockerConfig, len(configContent), configDigest, mediaTypeDockerLayerGzip, gz.Len(), layerDigest))
+	digest, _, _, _, err := UploadManifest("team/app", "1.0", io.NopCloser(bytes.NewReader(manifest)))
+	assert.Nil(err)
+
+	_, err = PrepareExport([]string{"team/app:2.0"}, ArchiveFormatOci)
+	assert.ErrorIs(err, ErrManifestUnknown)
+
+	export := func(format string) []byte {
+		archive, err := PrepareExport([]string{"team/app:1.0"}, format)
+		assert.Nil(err)
+		var buf bytes.Buffer
+		assert.Nil(archive.Write(&buf))
+		return buf.Bytes()
+	}
+	ociTar := export(ArchiveFormatOci)
+	dockerTar := export(ArchiveFormatDocker)
+
+	// the OCI image layout keeps the manifest as it is
+	useTestConfig(t, "")
+	res, err := Import(bytes.NewReader(ociTar), "", "")
+	assert.Nil(err)
+	row := res.GetArray("tables", nil).GetObject(0, nil).GetArray("rows", nil).GetArray(0, nil)
+	assert.Equal([]string{"team/app", "1.0", digest}, row.ToStringArray("")[:3])
+	assert.Equal(2, row.GetInt(3, 0))
+	tagDigest, err := getTagDigest("team/app", "1.0")
+	assert.Nil(err)
+	assert.Equal(digest, tagDigest)
+
+	// the docker archive gets a new manifest for the same config and layers
+	useTestConfig(t, "")
+	_, err = Import(bytes.NewReader(dockerTar), "", "")
+	assert.Nil(err)
+	manifestJson, err := getManifestJson("team/app", "1.0")
+	assert.Nil(err)
+	blobDigests, _ := getManifestBlobDigests(manifestJson)
+	assert.Equal([]string{configDigest, layerDigest}, blobDigests)
+	assert.Equal(mediaTypeDockerLayerGzip, manifestJson.GetArray("layers", nil).GetObject(0, nil).GetString("mediaType", ""))
+
+	// a corrupt layer is rejected
+	useTestConfig(t, "")
+	var corrupt bytes.Buffer
+	tw := tar.NewWriter(&corrupt)
+	tr := tar.NewReader(bytes.NewReader(ociTar))
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		content, _ := io.ReadAll(tr)
+		if header.Name == ociBlobPath(layerDigest) {
+			content[len(content)-1] ^= 0xff
+		}
+		tw.WriteHeader(header)
+		tw.Write(content)
+	}
+	tw.Close()
+	_, err = Import(&corrupt, "", "")
+	assert.ErrorIs(err, ErrDigestInvalid)
+	_, err = getTagDigest("team/app", "1.0")
+	assert.NotNil(err)
+
+	// an import is denied if it exceeds a quota
+	useTestConfig(t, `{"repo":{"dir":"repo","quotas":[{"name":"team/*","maxSizeMiB":1}]}}`)
+	pushTestBlob(t, "team/web", bytes.Repeat([]byte("a"), 1024*1024))
+	_, err = Import(bytes.NewReader(ociTar), "", "")
+	var quotaExceeded *QuotaExceededError
+	assert.ErrorAs(err, &quotaExceeded)
+
+	// and if it exceeds the quota of the importing account
+	useTestConfig(t, `{"repo":{"dir":"repo"},"accounts":[{"usr":"ci","pwd":"ci","quotaMiB":1,"images":[{"name":"team/*","push":true}]}]}`)
+	pushTestBlob(t, "team/web", bytes.Repeat([]byte("a"), 1024*1024))
+	_, err = Import(bytes.NewReader(ociTar), "", "")
+	assert.Nil(err)
+	useTestConfig(t, `{"repo":{"dir":"repo"},"accounts":[{"usr":"ci","pwd":"ci","quotaMiB":1,"images":[{"name":"team/*","push":true}]}]}`)
+	pushTestBlob(t, "team/web", bytes.Repeat([]byte("a"), 1024*1024))
+	_, err = Import(bytes.NewReader(ociTar), "", "ci")
+	assert.ErrorAs(err, &quotaExceeded)
+	assert.Equal("account ci", quotaExceeded.Name)
+
+	// larger archives are rejected before they are extracted
+	useTestConfig(t, `{"repo":{"dir":"repo","importMaxSizeMiB":1}}`)
+	var large bytes.Buffer
+	tw = tar.NewWriter(&large)
+	tw.WriteHeader(&tar.Header{Name: "blobs/sha256/large", Mode: 0600, Size: 2 * 1024 * 1024, Typeflag: tar.TypeReg})
+	tw.Write(make([]byte, 2*1024*1024))
+	tw.Close()
+	_, err = Import(&large, "", "")
+	assert.ErrorIs(err, ErrSizeInvalid)
+
+	// names which are no image names are rejected before anything is written
+	_, err = PrepareExport([]string{"../app:1.0"}, ArchiveFormatOci)
+	assert.ErrorIs(err, ErrNameInvalid)
+	useTestConfig(t, "")
+	_, err = Import(bytes.NewReader(ociTar), "../..", "")
+	assert.ErrorIs(err, ErrNameInvalid)
+	rename := func(archive []byte, name string) *bytes.Buffer {
+		var renamed bytes.Buffer
+		tw := tar.NewWriter(&renamed)
+		tr := tar.NewReader(bytes.NewReader(archive))
+		for {
+			header, err := tr.Next()
+			if err != nil {
+				break
+			}
+			content, _ := io.ReadAll(tr)
+			if header.Name == name {
+				content = bytes.ReplaceAll(content, []byte("team/app"), []byte("../../app"))
+				header.Size = int64(len(content))
+			}
+			tw.WriteHeader(header)
+			tw.Write(content)
+		}
+		tw.Close()
+		return &renamed
+	}
+	_, err = Import(rename(ociTar, "index.json"), "", "")
+	assert.ErrorIs(err, ErrNameInvalid)
+	_, err = Import(rename(dockerTar, "manifest.json"), "", "")
+	assert.ErrorIs(err, ErrNameInvalid)
+	imgs, err := getImages()
+	assert.Nil(err)
+	assert.Empty(imgs)
+	digests, err := getStoredBlobDigests()
+	assert.Nil(err)
+	assert.Empty(digests)
+}
+
+func TestImageRef(t *testing.T) {
+	assert := assert.New(t)
+
+	img, reference := parseImageRef("team/app:1.0")
+	assert.Equal("team/app", img)
+	assert.Equal("1.0", reference)
+	img, reference = parseImageRef("localhost:5000/app")
+	assert.Equal("localhost:5000/app", img)
+	assert.Equal("latest", reference)
+	img, reference = parseImageRef("app@sha256:1234")
+	assert.Equal("app", img)
+	assert.Equal("sha256:1234", reference)
+
+	assert.Equal("nginx:1.25", normalizeImageName("docker.io/library/nginx:1.25"))
+	assert.Equal("app:1.0", normalizeImageName("localhost:5000/app:1.0"))
+	assert.Equal("team/app:1.0", normalizeImageName("team/app:1.0"))
+}
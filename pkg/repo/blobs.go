@@ -0,0 +1,191 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"mosi-docker-registry/pkg/logging"
+	"mosi-docker-registry/pkg/storage"
+)
+
+// Blobs are stored once in the content-addressed blob store blobs/digest, no matter how many images use them.
+// An image references a blob with an empty link file v2/imagename/blobs/digest.
+// A blob is deleted from the store when the last image unlinks it.
+
+const emptyBlobDigest = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Moves a completed upload into the blob store and links it into the image.
+// The upload is dropped if the store already has the blob, e.g. a base layer shared by many images.
+func storeBlob(img, digest, uploadFn string) error {
+	unlock := lockBlob(digest)
+	defer unlock()
+	defer forgetImageUsage(img)
+
+	// link first, so a concurrent cleanup of another image keeps the blob
+	err := linkBlob(img, digest)
+	if err != nil {
+		deleteFile(uploadFn)
+		return err
+	}
+	storeFn := getBlobStoreFilename(digest)
+	if exists(storeFn) {
+		return deleteFile(uploadFn)
+	}
+	err = store().Move(uploadFn, storeFn)
+	if err != nil {
+		deleteFile(uploadFn)
+	}
+	return err
+}
+
+// Links a blob of the store into an image
+func linkBlob(img, digest string) error {
+	defer forgetImageUsage(img)
+	fn := getBlobLinkFilename(img, digest)
+	return writeBytes(fn, []byte{})
+}
+
+// Unlinks a blob from an image and deletes it from the store if no other image links it
+func unlinkBlob(img, digest string) error {
+	fn := getBlobLinkFilename(img, digest)
+	err := deleteFile(fn)
+	forgetImageUsage(img)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return deleteBlobIfUnlinked(digest)
+}
+
+func deleteBlobIfUnlinked(digest string) error {
+	unlock := lockBlob(digest)
+	defer unlock()
+
+	imgs, err := getImages()
+	if err != nil {
+		return err
+	}
+	for _, img := range imgs {
+		fn := getBlobLinkFilename(img, digest)
+		if exists(fn) {
+			return nil
+		}
+	}
+	storeFn := getBlobStoreFilename(digest)
+	logging.Debug(LOG, "deleting unlinked blob %s", digest)
+	forgetBlobSize(digest)
+	err = deleteFile(storeFn)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Deletes the blobs of the store which no image links, e.g. left over by an interrupted cleanup
+func cleanupBlobStore() error {
+	linked, err := getLinkedBlobDigests()
+	if err != nil {
+		return err
+	}
+	digests, err := getStoredBlobDigests()
+	if err != nil {
+		return err
+	}
+	for _, digest := range digests {
+		if linked[digest] {
+			continue
+		}
+		// checks the links again, an image may have linked the blob meanwhile
+		err = deleteBlobIfUnlinked(digest)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the store filename of a blob, ErrBlobUnknown if img does not link it
+func getLinkedBlobFilename(img, digest string) (string, error) {
+	linkFn := getBlobLinkFilename(img, digest)
+	storeFn := getBlobStoreFilename(digest)
+	if !exists(linkFn) || !exists(storeFn) {
+		return "", fmt.Errorf("%w: %s in %s", ErrBlobUnknown, digest, img)
+	}
+	return storeFn, nil
+}
+
+// Returns the digests of the blobs linked into an image
+func getImageBlobDigests(img string) ([]string, error) {
+	dir := getBlobLinksDir(img)
+	fns, err := listDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	digests := make([]string, len(fns))
+	for i, fn := range fns {
+		digests[i] = fn2digest(fn)
+	}
+	return digests, nil
+}
+
+// Returns the digests of the blobs linked into any image
+func getLinkedBlobDigests() (map[string]bool, error) {
+	imgs, err := getImages()
+	if err != nil {
+		return nil, err
+	}
+	linked := map[string]bool{}
+	for _, img := range imgs {
+		digests, err := getImageBlobDigests(img)
+		if err != nil {
+			return nil, err
+		}
+		for _, digest := range digests {
+			linked[digest] = true
+		}
+	}
+	return linked, nil
+}
+
+func getStoredBlobDigests() ([]string, error) {
+	dir := getBlobStoreDir()
+	fns, err := listDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	digests := make([]string, len(fns))
+	for i, fn := range fns {
+		digests[i] = fn2digest(fn)
+	}
+	return digests, nil
+}
+
+// blobs
+func getBlobStoreDir() string {
+	return "blobs"
+}
+
+// blobs/digest
+func getBlobStoreFilename(digest string) string {
+	return storage.Join(getBlobStoreDir(), digest2fn(digest))
+}
+
+// quarantine/digest holds a blob whose content does not match its digest
+func getQuarantineFilename(digest string) string {
+	return storage.Join("quarantine", digest2fn(digest))
+}
+
+// v2/imagename/blobs
+func getBlobLinksDir(img string) string {
+	return servedPath(img, "blobs")
+}
+
+// v2/imagename/blobs/digest
+func getBlobLinkFilename(img, digest string) string {
+	return storage.Join(getBlobLinksDir(img), digest2fn(digest))
+}
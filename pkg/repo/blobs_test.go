@@ -0,0 +1,66 @@
+package repo
+
+import (
+	"mosi-docker-registry/pkg/filesys"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlobStore(t *testing.T) {
+	assert := assert.New(t)
+
+	useTestConfig(t, "")
+
+	content := []byte("shared base layer")
+	digest, _ := filesys.CreateDigestFromBuffer(content)
+
+	for _, img := range []string{"team/app1", "team/app2"} {
+		assert.Equal(digest, pushTestBlob(t, img, content))
+	}
+	uploads, err := getUploads()
+	assert.Nil(err)
+	assert.Empty(uploads)
+
+	digests, err := getStoredBlobDigests()
+	assert.Nil(err)
+	assert.Equal([]string{digest}, digests)
+
+	storeFn, err := getLinkedBlobFilename("team/app2", digest)
+	assert.Nil(err)
+	_, err = getLinkedBlobFilename("team/app3", digest)
+	assert.ErrorIs(err, ErrBlobUnknown)
+
+	assert.Nil(unlinkBlob("team/app1", digest))
+	assert.True(exists(storeFn))
+	assert.Nil(unlinkBlob("team/app2", digest))
+	assert.False(exists(storeFn))
+}
+
+func TestMigrateBlobs(t *testing.T) {
+	assert := assert.New(t)
+
+	useTestConfig(t, "")
+
+	content := []byte("shared base layer")
+	digest, _ := filesys.CreateDigestFromBuffer(content)
+
+	// older versions stored a copy of the blob in each image
+	for _, img := range []string{"team/app1", "team/app2"} {
+		linkFn := getBlobLinkFilename(img, digest)
+		err := writeBytes(linkFn, content)
+		assert.Nil(err)
+		assert.Nil(migrateBlobs(img))
+		linkSize, _ := size(linkFn)
+		assert.Equal(int64(0), linkSize)
+	}
+
+	storeFn, err := getLinkedBlobFilename("team/app1", digest)
+	assert.Nil(err)
+	stored, _ := readBytes(storeFn)
+	assert.Equal(content, stored)
+
+	assert.Nil(migrateBlobs("team/app1"))
+	stored, _ = readBytes(storeFn)
+	assert.Equal(content, stored)
+}
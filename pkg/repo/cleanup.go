@@ -0,0 +1,67 @@
+package repo
+
+import (
+	"sync"
+	"time"
+)
+
+// Pushes and deletes request a cleanup of the image, which runs in the background off the request's path.
+// Requests for the same image are merged while the image waits for its cleanup.
+
+var pendingCleanups = map[string]bool{}
+var delayedCleanups = map[string]*time.Timer{}
+var cleanupMutex sync.Mutex
+var cleanupSignal = make(chan struct{}, 1)
+var cleanupOnce sync.Once
+
+func requestCleanup(img string) {
+	cleanupOnce.Do(func() {
+		go cleanupWorker()
+	})
+
+	cleanupMutex.Lock()
+	pendingCleanups[img] = true
+	cleanupMutex.Unlock()
+
+	select {
+	case cleanupSignal <- struct{}{}:
+	default:
+	}
+}
+
+// Requests a cleanup once the delay has passed, e.g. when the grace period of a blob ends
+func requestCleanupAfter(img string, delay time.Duration) {
+	cleanupMutex.Lock()
+	defer cleanupMutex.Unlock()
+
+	if _, ok := delayedCleanups[img]; ok {
+		return
+	}
+	delayedCleanups[img] = time.AfterFunc(delay, func() {
+		cleanupMutex.Lock()
+		delete(delayedCleanups, img)
+		cleanupMutex.Unlock()
+		requestCleanup(img)
+	})
+}
+
+func cleanupWorker() {
+	for range cleanupSignal {
+		for {
+			cleanupMutex.Lock()
+			imgs := make([]string, 0, len(pendingCleanups))
+			for img := range pendingCleanups {
+				imgs = append(imgs, img)
+			}
+			pendingCleanups = map[string]bool{}
+			cleanupMutex.Unlock()
+
+			if len(imgs) == 0 {
+				break
+			}
+			for _, img := range imgs {
+				CleanupImage(img)
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package repo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCleanupGracePeriod(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := useTestConfig(t, "")
+
+	// a layer of a push whose manifest has not arrived yet
+	digest := pushTestBlob(t, "team/app", []byte("layer of a push in progress"))
+
+	CleanupImage("team/app")
+	_, err := getLinkedBlobFilename("team/app", digest)
+	assert.Nil(err)
+	assert.True(imageExists("team/app"))
+
+	writeTestConfig(t, dir, `{"repo":{"dir":"repo","blobGracePeriodMinutes":0}}`)
+
+	CleanupImage("team/app")
+	_, err = getLinkedBlobFilename("team/app", digest)
+	assert.ErrorIs(err, ErrBlobUnknown)
+	assert.False(imageExists("team/app"))
+	digests, err := getStoredBlobDigests()
+	assert.Nil(err)
+	assert.Empty(digests)
+}
+
+func TestCleanupKeepsNewUploads(t *testing.T) {
+	assert := assert.New(t)
+
+	useTestConfig(t, "")
+
+	// a cleanup deleting the empty image holds its lock
+	unlock := lockImage("team/app")
+	created := make(chan string)
+	go func() {
+		uploadUuid, err := CreateBlobUpload("team/app")
+		assert.Nil(err)
+		created <- uploadUuid
+	}()
+	select {
+	case <-created:
+		assert.Fail("upload created during the cleanup")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+
+	uploadUuid := <-created
+	CleanupImage("team/app")
+	_, err := GetBlobUploadSize("team/app", uploadUuid)
+	assert.Nil(err)
+}
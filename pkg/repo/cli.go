@@ -1,7 +1,10 @@
 package repo
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
+	"mosi-docker-registry/pkg/config"
 	"mosi-docker-registry/pkg/filesys"
 	"mosi-docker-registry/pkg/json"
 	"mosi-docker-registry/pkg/wildcard"
@@ -28,42 +31,83 @@ func listImages(imgPattern string) (*json.JsonObject, error) {
 	var table *json.JsonObject = nil
 	var rows *json.JsonArray = nil
 
+	// blobs shared by several images are stored only once
+	storedBlobs := map[string]int64{}
+	var nImageBytes int64 = 0
+
+	// the quota column shows the usage against the quotas of the config
+	hasQuotas := len(config.RepoQuotas()) > 0
+	quotaUsages := map[string]int64{}
+
 	for _, img := range imgs {
 		if wildcard.Matches(img, imgPattern) {
 
 			if table == nil {
 				table = json.NewJsonObject()
-				table.Put("fields", json.JsonArrayFromStrings("Image", "Tags", "Blobs", "Size"))
+				if hasQuotas {
+					table.Put("fields", json.JsonArrayFromStrings("Image", "Tags", "Blobs", "Size", "Quota"))
+				} else {
+					table.Put("fields", json.JsonArrayFromStrings("Image", "Tags", "Blobs", "Size"))
+				}
 				tables.Add(table)
 
 				rows = json.NewJsonArray(0)
 				table.Put("rows", rows)
 			}
 
+			// an image whose first manifest has not arrived yet has no tags
 			nTags := -1
 			tags, err := getImageTags(img)
-			if err != nil {
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
 				return nil, err
 			}
 			nTags = len(tags)
 
 			nBlobs := -1
 			var nBlobBytes int64 = 0
-			blobs, err := getBlobFiles(img)
+			blobDigests, err := getImageBlobDigests(img)
 			if err != nil {
 				return nil, err
 			}
-			nBlobs = len(blobs)
+			nBlobs = len(blobDigests)
 
-			for _, blob := range blobs {
-				size, err := filesys.Size(blob)
+			for _, blobDigest := range blobDigests {
+				storeFn := getBlobStoreFilename(blobDigest)
+				size, err := size(storeFn)
+				if errors.Is(err, fs.ErrNotExist) {
+					continue
+				}
 				if err != nil {
 					return nil, err
 				}
 				nBlobBytes += size
+				storedBlobs[blobDigest] = size
+			}
+			nImageBytes += nBlobBytes
+			if !hasQuotas {
+				rows.Add(json.JsonArrayFromAny(img, nTags, nBlobs, filesys.Bytes2IEC(nBlobBytes)))
+				continue
 			}
-			rows.Add(json.JsonArrayFromAny(img, nTags, nBlobs, filesys.Bytes2IEC(nBlobBytes)))
+			quota, err := getImageQuota(img, quotaUsages)
+			if err != nil {
+				return nil, err
+			}
+			if quota == "" {
+				quota = "-"
+			}
+			rows.Add(json.JsonArrayFromAny(img, nTags, nBlobs, filesys.Bytes2IEC(nBlobBytes), quota))
+		}
+	}
+
+	if table != nil {
+		var nStoredBytes int64 = 0
+		for _, size := range storedBlobs {
+			nStoredBytes += size
 		}
+		total := json.NewJsonObject()
+		total.Put("fields", json.JsonArrayFromStrings("Images", "Stored Blobs", "Stored Size", "Shared"))
+		total.Put("rows", json.NewJsonArray(0).Add(json.JsonArrayFromAny(rows.Len(), len(storedBlobs), filesys.Bytes2IEC(nStoredBytes), filesys.Bytes2IEC(nImageBytes-nStoredBytes))))
+		tables.Add(total)
 	}
 	return res, nil
 }
@@ -88,33 +132,44 @@ func listLayers(imgPattern, tagPattern string) (*json.JsonObject, error) {
 			for _, tag := range tags {
 				if wildcard.Matches(tag, tagPattern) {
 
+					manifestJson, err := getManifestJson(img, tag)
+					if err != nil {
+						return nil, err
+					}
+
 					table := json.NewJsonObject()
 					tables.Add(table)
-					table.Put("fields", json.JsonArrayFromStrings("Image", "Tag", "Layer", "Size"))
 					rows := json.NewJsonArray(0)
 					table.Put("rows", rows)
 
-					manifestJson, err := getManifestJson(img, tag)
-					if err != nil {
-						return nil, err
+					if !isIndexManifest(manifestJson) {
+						table.Put("fields", json.JsonArrayFromStrings("Image", "Tag", "Layer", "Size"))
+						err = addLayerRows(rows, img, manifestJson, img, tag)
+						if err != nil {
+							return nil, err
+						}
+						continue
 					}
 
-					layerDigests, err := getManifestLayerDigests(manifestJson)
+					// multi-arch image: list the layers of each platform's manifest
+					table.Put("fields", json.JsonArrayFromStrings("Image", "Tag", "Platform", "Layer", "Size"))
+					manifests, err := getIndexManifests(manifestJson)
 					if err != nil {
 						return nil, err
 					}
-
-					for _, layerDigest := range layerDigests {
-						servedBlobFn, err := getBlobServedFilename(img, layerDigest)
+					for i := 0; i < manifests.Len(); i++ {
+						manifest := manifests.GetObjectUnsafe(i)
+						childJson, err := getManifestJson(img, manifest.GetString("digest", ""))
 						if err != nil {
 							return nil, err
 						}
-						nLayerBytes, err := filesys.Size(servedBlobFn)
+						if isIndexManifest(childJson) {
+							continue
+						}
+						err = addLayerRows(rows, img, childJson, img, tag, getIndexManifestPlatform(manifest))
 						if err != nil {
 							return nil, err
 						}
-
-						rows.Add(json.JsonArrayFromStrings(img, tag, layerDigest, filesys.Bytes2IEC(nLayerBytes)))
 					}
 				}
 			}
@@ -123,14 +178,44 @@ func listLayers(imgPattern, tagPattern string) (*json.JsonObject, error) {
 	return res, nil
 }
 
+func addLayerRows(rows *json.JsonArray, img string, manifestJson *json.JsonObject, columns ...string) error {
+	layerDigests, err := getManifestLayerDigests(manifestJson)
+	if err != nil {
+		return err
+	}
+
+	for _, layerDigest := range layerDigests {
+		servedBlobFn, err := getLinkedBlobFilename(img, layerDigest)
+		if err != nil {
+			return err
+		}
+		nLayerBytes, err := size(servedBlobFn)
+		if err != nil {
+			return err
+		}
+
+		rows.Add(json.JsonArrayFromStrings(append(columns, layerDigest, filesys.Bytes2IEC(nLayerBytes))...))
+	}
+	return nil
+}
+
 func Delete(imgPattern, tagPattern string, dry bool) (*json.JsonObject, error) {
 	if tagPattern == "" {
 		tagPattern = "*"
 	}
-	return deleteImages(imgPattern, tagPattern, dry)
+	return deleteImages(imgPattern, func(img string, tags []string) ([]string, error) {
+		matchingTags := []string{}
+		for _, tag := range tags {
+			if wildcard.Matches(tag, tagPattern) {
+				matchingTags = append(matchingTags, tag)
+			}
+		}
+		return matchingTags, nil
+	}, dry)
 }
 
-func deleteImages(imgPattern, tagPattern string, dry bool) (*json.JsonObject, error) {
+// Deletes the tags selectTags returns of each image matching imgPattern and cleans up the images afterwards
+func deleteImages(imgPattern string, selectTags func(img string, tags []string) ([]string, error), dry bool) (*json.JsonObject, error) {
 	imgs, err := getImages()
 	if err != nil {
 		return nil, err
@@ -149,34 +234,40 @@ func deleteImages(imgPattern, tagPattern string, dry bool) (*json.JsonObject, er
 		if wildcard.Matches(img, imgPattern) {
 
 			tags, err := getImageTags(img)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			tags, err = selectTags(img, tags)
 			if err != nil {
 				return nil, err
 			}
 			for _, tag := range tags {
-				if wildcard.Matches(tag, tagPattern) {
-
-					imgsDeleted[img] = true
+				imgsDeleted[img] = true
 
-					if table == nil {
-						table = json.NewJsonObject()
-						table.Put("fields", json.JsonArrayFromStrings("Image", "Tag", "Deleted"))
-						tables.Add(table)
+				if table == nil {
+					table = json.NewJsonObject()
+					table.Put("fields", json.JsonArrayFromStrings("Image", "Tag", "Deleted"))
+					tables.Add(table)
 
-						rows = json.NewJsonArray(0)
-						table.Put("rows", rows)
-					}
+					rows = json.NewJsonArray(0)
+					table.Put("rows", rows)
+				}
 
-					s := "NO"
-					if !dry {
-						s = "YES"
+				s := "NO"
+				if !dry {
+					s = "YES"
 
-						err = deleteImage(img, tag)
-						if err != nil {
-							s = fmt.Sprintf("NO, ERROR: %v", err)
-						}
+					unlock := lockImage(img)
+					err = deleteImage(img, tag)
+					unlock()
+					if err != nil {
+						s = fmt.Sprintf("NO, ERROR: %v", err)
 					}
-					rows.Add(json.JsonArrayFromStrings(img, tag, s))
 				}
+				rows.Add(json.JsonArrayFromStrings(img, tag, s))
 			}
 		}
 	}
@@ -0,0 +1,36 @@
+package repo
+
+import (
+	"errors"
+	"strings"
+)
+
+// The errors returned by the repository functions get wrapped with details, e.g. fmt.Errorf("%w: %s", ErrBlobUnknown, digest).
+// The server maps them to the error codes of the distribution spec with errors.Is.
+
+var ErrNameUnknown = errors.New("repository name not known to registry")
+var ErrNameInvalid = errors.New("invalid repository name")
+var ErrBlobUnknown = errors.New("blob unknown to registry")
+var ErrBlobUploadUnknown = errors.New("blob upload unknown to registry")
+var ErrBlobUploadInvalid = errors.New("blob upload invalid")
+var ErrManifestUnknown = errors.New("manifest unknown to registry")
+var ErrManifestInvalid = errors.New("manifest invalid")
+var ErrManifestBlobUnknown = errors.New("manifest references blobs unknown to registry")
+var ErrManifestNotAccepted = errors.New("manifest media type not accepted")
+var ErrTagInvalid = errors.New("invalid tag")
+var ErrDigestInvalid = errors.New("invalid digest")
+var ErrSizeInvalid = errors.New("provided length did not match content length")
+var ErrDenied = errors.New("requested access to the resource is denied")
+
+// Lists the blobs and manifests a pushed manifest references but which are not in the repository
+type ManifestBlobUnknownError struct {
+	Digests []string
+}
+
+func (e *ManifestBlobUnknownError) Error() string {
+	return ErrManifestBlobUnknown.Error() + ": " + strings.Join(e.Digests, ", ")
+}
+
+func (e *ManifestBlobUnknownError) Unwrap() error {
+	return ErrManifestBlobUnknown
+}
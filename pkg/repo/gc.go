@@ -0,0 +1,319 @@
+package repo
+
+import (
+	"errors"
+	"io/fs"
+	"mosi-docker-registry/pkg/config"
+	"mosi-docker-registry/pkg/filesys"
+	"mosi-docker-registry/pkg/json"
+	"mosi-docker-registry/pkg/logging"
+	"path"
+	"time"
+)
+
+// The garbage collection marks the manifests and blobs in use and sweeps everything else of all images:
+// manifests which are not reachable from a tag (only if untagged is set), blob links no kept manifest references,
+// stale upload sessions and blobs of the blob store which no image links, e.g. left over by a crash.
+// Manifests pushed and blobs linked within the grace period are kept like in a cleanup.
+
+type gcImageResult struct {
+	img       string
+	manifests int
+	blobs     int
+	uploads   int
+	bytes     int64
+	// blob digests the image keeps and sweeps
+	keptBlobs  map[string]bool
+	sweptBlobs []string
+}
+
+// Runs the garbage collection, dry only reports what would be deleted
+func GarbageCollect(dry, untagged bool) (*json.JsonObject, error) {
+	imgs, err := getImages()
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*gcImageResult{}
+	keptBlobs := map[string]bool{}
+	for _, img := range imgs {
+		result, err := gcImage(img, dry, untagged)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+		for digest := range result.keptBlobs {
+			keptBlobs[digest] = true
+		}
+	}
+
+	// a swept blob is reclaimed only if no other image keeps it, its size counts for the first image sweeping it
+	reclaimed := map[string]bool{}
+	for _, result := range results {
+		for _, digest := range result.sweptBlobs {
+			if keptBlobs[digest] || reclaimed[digest] {
+				continue
+			}
+			reclaimed[digest] = true
+			result.bytes += getStoredBlobSize(digest)
+		}
+	}
+
+	storeResult := &gcImageResult{img: "(blob store)"}
+	storedDigests, err := getStoredBlobDigests()
+	if err != nil {
+		return nil, err
+	}
+	for _, digest := range storedDigests {
+		if keptBlobs[digest] {
+			continue
+		}
+		if !reclaimed[digest] {
+			// no image links the blob
+			storeResult.blobs++
+			storeResult.bytes += getStoredBlobSize(digest)
+		}
+		if !dry {
+			// checks the links again, an image may have linked the blob meanwhile
+			err = deleteBlobIfUnlinked(digest)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if storeResult.blobs > 0 {
+		results = append(results, storeResult)
+	}
+
+	tables := json.NewJsonArray(0)
+	res := json.NewJsonObject()
+	res.Put("tables", tables)
+
+	deleted := "YES"
+	if dry {
+		deleted = "NO"
+	}
+	rows := json.NewJsonArray(0)
+	var nBytes int64 = 0
+	for _, result := range results {
+		if result.manifests == 0 && result.blobs == 0 && result.uploads == 0 {
+			continue
+		}
+		nBytes += result.bytes
+		rows.Add(json.JsonArrayFromAny(result.img, result.manifests, result.blobs, result.uploads, filesys.Bytes2IEC(result.bytes), deleted))
+	}
+	if rows.Len() > 0 {
+		table := json.NewJsonObject()
+		table.Put("fields", json.JsonArrayFromStrings("Image", "Manifests", "Blobs", "Uploads", "Reclaimable", "Deleted"))
+		table.Put("rows", rows)
+		tables.Add(table)
+	}
+	total := json.NewJsonObject()
+	total.Put("fields", json.JsonArrayFromStrings("Images", "Reclaimable"))
+	total.Put("rows", json.NewJsonArray(0).Add(json.JsonArrayFromAny(rows.Len(), filesys.Bytes2IEC(nBytes))))
+	tables.Add(total)
+
+	logging.Info(LOG, "garbage collection found %s in %d images, dry %v, untagged %v", filesys.Bytes2IEC(nBytes), rows.Len(), dry, untagged)
+	return res, nil
+}
+
+// Marks and sweeps an image while holding its lock
+func gcImage(img string, dry, untagged bool) (*gcImageResult, error) {
+	unlock := lockImage(img)
+	defer unlock()
+
+	result := &gcImageResult{img: img, keptBlobs: map[string]bool{}, sweptBlobs: []string{}}
+
+	manifestFns, err := getManifestFiles(img)
+	if err != nil {
+		return nil, err
+	}
+	manifests := map[string]*json.JsonObject{}
+	for _, fn := range manifestFns {
+		manifestJson, err := readJson(fn)
+		if err != nil {
+			logging.Warn(LOG, "garbage collection skips invalid manifest %s", fn)
+			continue
+		}
+		manifests[fn2digest(path.Base(fn))] = manifestJson
+	}
+
+	tags, err := getImageTags(img)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	marked, err := markManifests(img, manifests, tags, untagged)
+	if err != nil {
+		return nil, err
+	}
+
+	// a manifest pushed within the grace period is kept, e.g. the child manifests of an index
+	// or a referrer whose subject is not tagged yet
+	gracePeriod := config.BlobGracePeriod()
+	for digest := range manifests {
+		if marked[digest] {
+			continue
+		}
+		fn := getManifestRevisionFilename(img, digest)
+		fileInfo, err := store().Stat(fn)
+		if err == nil && time.Since(fileInfo.Modified) < gracePeriod {
+			marked[digest] = true
+		}
+	}
+
+	for digest, manifestJson := range manifests {
+		if !marked[digest] {
+			continue
+		}
+		blobDigests, err := getManifestBlobDigests(manifestJson)
+		if err != nil {
+			continue
+		}
+		for _, blobDigest := range blobDigests {
+			result.keptBlobs[blobDigest] = true
+		}
+	}
+
+	for digest := range manifests {
+		if marked[digest] {
+			continue
+		}
+		fn := getManifestRevisionFilename(img, digest)
+		manifestSize, err := size(fn)
+		if err != nil {
+			// deleted with a manifest deleted before
+			continue
+		}
+		result.manifests++
+		result.bytes += manifestSize
+		if !dry {
+			logging.Info(LOG, "garbage collection deleting untagged manifest %s@%s", img, digest)
+			err = deleteManifestRevision(img, digest)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	blobDigests, err := getImageBlobDigests(img)
+	if err != nil {
+		return nil, err
+	}
+	for _, blobDigest := range blobDigests {
+		if result.keptBlobs[blobDigest] {
+			continue
+		}
+		linkFn := getBlobLinkFilename(img, blobDigest)
+		fileInfo, err := store().Stat(linkFn)
+		if err == nil && time.Since(fileInfo.Modified) < gracePeriod {
+			result.keptBlobs[blobDigest] = true
+			continue
+		}
+		result.blobs++
+		result.sweptBlobs = append(result.sweptBlobs, blobDigest)
+		if !dry {
+			err = deleteFile(linkFn)
+			forgetImageUsage(img)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+		}
+	}
+
+	maxIdle := config.UploadMaxIdle()
+	uploadsDir := getBlobUploadsDir(img)
+	uploadUuids, err := listDir(uploadsDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for _, uploadUuid := range uploadUuids {
+		fileInfo, err := store().Stat(servedPath(img, "uploads", uploadUuid))
+		if err != nil || maxIdle <= 0 || time.Since(fileInfo.Modified) <= maxIdle {
+			continue
+		}
+		result.uploads++
+		result.bytes += fileInfo.Size
+		if !dry {
+			err = CancelBlobUpload(img, uploadUuid)
+			if err != nil && !errors.Is(err, ErrBlobUploadUnknown) {
+				return nil, err
+			}
+		}
+	}
+
+	if !dry {
+		err = deleteImageIfEmpty(img)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// Returns the manifests in use: all of them, or with untagged only the manifests reachable from a tag,
+// i.e. tagged manifests, the manifests of their manifest lists and image indexes and the referrers of manifests in use
+func markManifests(img string, manifests map[string]*json.JsonObject, tags []string, untagged bool) (map[string]bool, error) {
+	marked := map[string]bool{}
+	if !untagged {
+		for digest := range manifests {
+			marked[digest] = true
+		}
+		return marked, nil
+	}
+
+	pending := []string{}
+	for _, tag := range tags {
+		digest, err := getTagDigest(img, tag)
+		if err != nil {
+			return nil, err
+		}
+		pending = append(pending, digest)
+	}
+
+	for len(pending) > 0 {
+		digest := pending[0]
+		pending = pending[1:]
+		if marked[digest] {
+			continue
+		}
+		marked[digest] = true
+
+		if manifestJson, ok := manifests[digest]; ok && isIndexManifest(manifestJson) {
+			childDigests, err := getIndexManifestDigests(manifestJson)
+			if err == nil {
+				pending = append(pending, childDigests...)
+			}
+		}
+		for referrerDigest, manifestJson := range manifests {
+			if getManifestSubject(manifestJson) == digest {
+				pending = append(pending, referrerDigest)
+			}
+		}
+	}
+	return marked, nil
+}
+
+// Deletes the image if it has no manifests, tags, blob links or uploads left
+func deleteImageIfEmpty(img string) error {
+	for _, name := range reservedNames {
+		fns, err := listDir(servedPath(img, name))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if len(fns) > 0 {
+			return nil
+		}
+	}
+	dir := getImageServedDir(img)
+	logging.Debug(LOG, "deleting image directory %s", dir)
+	return deleteImageDir(dir)
+}
+
+func getStoredBlobSize(digest string) int64 {
+	storeFn := getBlobStoreFilename(digest)
+	blobSize, err := size(storeFn)
+	if err != nil {
+		return 0
+	}
+	return blobSize
+}
@@ -0,0 +1,105 @@
+package repo
+
+import (
+	"fmt"
+	"mosi-docker-registry/pkg/config"
+	"mosi-docker-registry/pkg/filesys"
+	"mosi-docker-registry/pkg/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGarbageCollect(t *testing.T) {
+	assert := assert.New(t)
+
+	useTestConfig(t, `{"repo":{"dir":"repo","blobGracePeriodMinutes":0,"uploadMaxIdleMinutes":1}}`)
+
+	pushManifest := func(tag string, blobDigests ...string) string {
+		content := []byte(fmt.Sprintf(`{"schemaVersion":2,"config":{"digest":"%s"},"layers":[{"digest":"%s"}]}`, blobDigests[0], blobDigests[1]))
+		digest, _ := filesys.CreateDigestFromBuffer(content)
+		revisionFn := getManifestRevisionFilename("app", digest)
+		assert.Nil(writeBytes(revisionFn, content))
+		if tag != "" {
+			assert.Nil(writeTag("app", tag, digest))
+		}
+		return digest
+	}
+
+	configDigest := pushTestBlob(t, "app", []byte("config"))
+	pushManifest("1.0", configDigest, pushTestBlob(t, "app", []byte("tagged layer")))
+	untaggedLayerDigest := pushTestBlob(t, "app", []byte("untagged layer"))
+	untaggedDigest := pushManifest("", configDigest, untaggedLayerDigest)
+	pushTestBlob(t, "app", []byte("orphaned layer"))
+
+	staleUuid, err := CreateBlobUpload("app")
+	assert.Nil(err)
+	staleFn, _ := getBlobUploadFilename("app", staleUuid)
+	past := time.Now().Add(-time.Hour)
+	assert.Nil(os.Chtimes(filepath.Join(config.RepoDir(), filepath.FromSlash(staleFn)), past, past))
+
+	// a blob left over by a crash
+	crashDigest, _ := filesys.CreateDigestFromBuffer([]byte("crash"))
+	storeFn := getBlobStoreFilename(crashDigest)
+	assert.Nil(writeBytes(storeFn, []byte("crash")))
+
+	rows := func(res *json.JsonObject) *json.JsonArray {
+		return res.GetArray("tables", json.NewJsonArray(0)).GetObject(0, json.NewJsonObject()).GetArray("rows", json.NewJsonArray(0))
+	}
+
+	res, err := GarbageCollect(true, true)
+	assert.Nil(err)
+	assert.Equal(2, rows(res).Len())
+	row := rows(res).GetArray(0, nil)
+	assert.Equal("app", row.GetString(0, ""))
+	assert.Equal(1, row.GetInt(1, -1))
+	assert.Equal(2, row.GetInt(2, -1))
+	assert.Equal(1, row.GetInt(3, -1))
+	assert.Equal("NO", row.GetString(5, ""))
+	assert.Equal("(blob store)", rows(res).GetArray(1, nil).GetString(0, ""))
+	digests, _ := getStoredBlobDigests()
+	assert.Len(digests, 5)
+
+	// untagged manifests are kept without untagged
+	_, err = GarbageCollect(false, false)
+	assert.Nil(err)
+	digests, _ = getStoredBlobDigests()
+	assert.Len(digests, 3)
+	_, err = GetBlobUploadSize("app", staleUuid)
+	assert.ErrorIs(err, ErrBlobUploadUnknown)
+
+	_, err = GarbageCollect(false, true)
+	assert.Nil(err)
+	_, err = resolveManifest("app", untaggedDigest)
+	assert.NotNil(err)
+	_, err = getLinkedBlobFilename("app", untaggedLayerDigest)
+	assert.ErrorIs(err, ErrBlobUnknown)
+	digests, _ = getStoredBlobDigests()
+	assert.Len(digests, 2)
+
+	res, err = GarbageCollect(true, true)
+	assert.Nil(err)
+	assert.Equal(1, res.GetArray("tables", nil).Len())
+	assert.Equal(0, rows(res).GetArray(0, nil).GetInt(0, -1))
+}
+
+func TestGarbageCollectGracePeriod(t *testing.T) {
+	assert := assert.New(t)
+
+	useTestConfig(t, `{"repo":{"dir":"repo","blobGracePeriodMinutes":60}}`)
+
+	// a child manifest pushed by digest before its index
+	content := []byte(`{"schemaVersion":2,"config":{"digest":"sha256:c"},"layers":[]}`)
+	digest, _ := filesys.CreateDigestFromBuffer(content)
+	revisionFn := getManifestRevisionFilename("app", digest)
+	assert.Nil(writeBytes(revisionFn, content))
+
+	res, err := GarbageCollect(false, true)
+	assert.Nil(err)
+	assert.Equal(1, res.GetArray("tables", nil).Len())
+	_, err = resolveManifest("app", digest)
+	assert.Nil(err)
+}
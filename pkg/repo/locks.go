@@ -0,0 +1,54 @@
+package repo
+
+import (
+	"sync"
+)
+
+// Changes of an image's manifests, tags and blob links and new upload sessions hold the image's lock,
+// so a cleanup of the image sees them completely or not at all.
+// Changes of a blob in the blob store hold the blob's lock, so a blob does not get deleted while another image links it.
+// A blob lock may be taken while holding an image lock, never the other way round.
+
+type keyedMutex struct {
+	mutex sync.Mutex
+	locks map[string]*refMutex
+}
+
+type refMutex struct {
+	sync.Mutex
+	refs int
+}
+
+var imageLocks = keyedMutex{locks: map[string]*refMutex{}}
+var blobLocks = keyedMutex{locks: map[string]*refMutex{}}
+
+// Locks the key and returns the function to unlock it
+func (k *keyedMutex) lock(key string) func() {
+	k.mutex.Lock()
+	m, ok := k.locks[key]
+	if !ok {
+		m = &refMutex{}
+		k.locks[key] = m
+	}
+	m.refs++
+	k.mutex.Unlock()
+
+	m.Lock()
+	return func() {
+		m.Unlock()
+		k.mutex.Lock()
+		m.refs--
+		if m.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mutex.Unlock()
+	}
+}
+
+func lockImage(img string) func() {
+	return imageLocks.lock(img)
+}
+
+func lockBlob(digest string) func() {
+	return blobLocks.lock(digest)
+}
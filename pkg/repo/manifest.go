@@ -0,0 +1,192 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"mosi-docker-registry/pkg/json"
+	"strings"
+)
+
+const (
+	MediaTypeDockerManifestV1   = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOciManifest        = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOciIndex           = "application/vnd.oci.image.index.v1+json"
+)
+
+func isIndexMediaType(mediaType string) bool {
+	return mediaType == MediaTypeDockerManifestList || mediaType == MediaTypeOciIndex
+}
+
+// The media type is read from the manifest content.
+// Docker manifests always have a mediaType field. OCI manifests should have one, otherwise the type is derived from the manifest's structure.
+func getManifestMediaType(manifestJson *json.JsonObject) string {
+	if mediaType := manifestJson.GetString("mediaType", ""); len(mediaType) > 0 {
+		return mediaType
+	}
+	if manifestJson.GetInt("schemaVersion", 0) == 1 {
+		return MediaTypeDockerManifestV1
+	}
+	if manifestJson.Has("manifests") {
+		return MediaTypeOciIndex
+	}
+	return MediaTypeOciManifest
+}
+
+// Returns true if mediaType matches one of the accepted media types, e.g. "application/*".
+// Clients which do not send an Accept header accept any media type.
+func IsMediaTypeAccepted(acceptedMediaTypes []string, mediaType string) bool {
+	if len(acceptedMediaTypes) == 0 {
+		return true
+	}
+	for _, accepted := range acceptedMediaTypes {
+		if accepted == mediaType || accepted == "*/*" {
+			return true
+		}
+		if strings.HasSuffix(accepted, "/*") && strings.HasPrefix(mediaType, accepted[:len(accepted)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+func isIndexManifest(manifestJson *json.JsonObject) bool {
+	return isIndexMediaType(getManifestMediaType(manifestJson))
+}
+
+// Returns the descriptors of the manifests referenced by a manifest list or image index
+func getIndexManifests(manifestJson *json.JsonObject) (*json.JsonArray, error) {
+	if manifests := manifestJson.GetArray("manifests", nil); manifests != nil {
+		return manifests, nil
+	}
+	return nil, errors.New("failed to get manifests from index")
+}
+
+func getIndexManifestDigests(manifestJson *json.JsonObject) ([]string, error) {
+	manifests, err := getIndexManifests(manifestJson)
+	if err != nil {
+		return nil, err
+	}
+	digests := make([]string, manifests.Len())
+	for i := 0; i < manifests.Len(); i++ {
+		manifest := manifests.GetObject(i, nil)
+		if manifest == nil {
+			return nil, errors.New("failed to get manifest from index")
+		}
+		digest := manifest.GetString("digest", "")
+		if len(digest) == 0 {
+			return nil, errors.New("failed to get digest from index manifest")
+		}
+		digests[i] = digest
+	}
+	return digests, nil
+}
+
+// "linux/arm64/v8"
+func getIndexManifestPlatform(manifest *json.JsonObject) string {
+	platform := manifest.GetObject("platform", nil)
+	if platform == nil {
+		return ""
+	}
+	s := platform.GetString("os", "") + "/" + platform.GetString("architecture", "")
+	if variant := platform.GetString("variant", ""); len(variant) > 0 {
+		s += "/" + variant
+	}
+	return s
+}
+
+// Returns the digests of the config and layer blobs referenced by an image manifest.
+// Manifest lists and image indexes do not reference any blobs, only other manifests.
+func getManifestBlobDigests(manifestJson *json.JsonObject) ([]string, error) {
+	if isIndexManifest(manifestJson) {
+		return []string{}, nil
+	}
+
+	configDigest, err := getManifestConfigDigest(manifestJson)
+	if err != nil {
+		return nil, err
+	}
+
+	layerDigests, err := getManifestLayerDigests(manifestJson)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]string{configDigest}, layerDigests...), nil
+}
+
+// Rejects manifests which are not schema version 2 image manifests, manifest lists or image indexes
+// and manifests referencing blobs or manifests which are not in the repository.
+func validateManifest(img string, manifestJson *json.JsonObject) error {
+	if schemaVersion := manifestJson.GetInt("schemaVersion", 0); schemaVersion != 2 {
+		return fmt.Errorf("%w: unsupported schema version %d", ErrManifestInvalid, schemaVersion)
+	}
+
+	mediaType := getManifestMediaType(manifestJson)
+	descriptors := []*json.JsonObject{}
+	switch mediaType {
+	case MediaTypeDockerManifest, MediaTypeOciManifest:
+		config := manifestJson.GetObject("config", nil)
+		if config == nil {
+			return fmt.Errorf("%w: missing config", ErrManifestInvalid)
+		}
+		descriptors = append(descriptors, config)
+		layers := manifestJson.GetArray("layers", nil)
+		if layers == nil {
+			return fmt.Errorf("%w: missing layers", ErrManifestInvalid)
+		}
+		for i := 0; i < layers.Len(); i++ {
+			descriptors = append(descriptors, layers.GetObject(i, nil))
+		}
+	case MediaTypeDockerManifestList, MediaTypeOciIndex:
+		manifests := manifestJson.GetArray("manifests", nil)
+		if manifests == nil {
+			return fmt.Errorf("%w: missing manifests", ErrManifestInvalid)
+		}
+		for i := 0; i < manifests.Len(); i++ {
+			descriptors = append(descriptors, manifests.GetObject(i, nil))
+		}
+	default:
+		return fmt.Errorf("%w: unsupported media type %s", ErrManifestInvalid, mediaType)
+	}
+
+	missing := []string{}
+	for _, descriptor := range descriptors {
+		if descriptor == nil {
+			return fmt.Errorf("%w: invalid descriptor", ErrManifestInvalid)
+		}
+		digest := descriptor.GetString("digest", "")
+		if !isValidDigest(digest) {
+			return fmt.Errorf("%w: invalid descriptor digest %s", ErrManifestInvalid, digest)
+		}
+		// non-distributable layers, e.g. of Windows base images, are downloaded from their urls
+		if urls := descriptor.GetArray("urls", nil); urls != nil && urls.Len() > 0 {
+			continue
+		}
+		exists, err := existsReferencedContent(img, digest, isIndexMediaType(mediaType))
+		if err != nil {
+			return err
+		}
+		if !exists {
+			missing = append(missing, digest)
+		}
+	}
+	if len(missing) > 0 {
+		return &ManifestBlobUnknownError{Digests: missing}
+	}
+	return nil
+}
+
+// Image manifests reference blobs, manifest lists and image indexes reference manifests
+func existsReferencedContent(img, digest string, isManifest bool) (bool, error) {
+	if !isManifest {
+		_, err := getLinkedBlobFilename(img, digest)
+		if errors.Is(err, ErrBlobUnknown) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	fn := getManifestRevisionFilename(img, digest)
+	return exists(fn), nil
+}
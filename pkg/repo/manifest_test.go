@@ -0,0 +1,73 @@
+package repo
+
+import (
+	"errors"
+	"mosi-docker-registry/pkg/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifestMediaType(t *testing.T) {
+	assert := assert.New(t)
+
+	manifestJson, _ := json.DecodeString(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json","config":{},"layers":[]}`)
+	assert.Equal(MediaTypeDockerManifest, getManifestMediaType(manifestJson))
+
+	manifestJson, _ = json.DecodeString(`{"schemaVersion":2,"config":{},"layers":[]}`)
+	assert.Equal(MediaTypeOciManifest, getManifestMediaType(manifestJson))
+
+	manifestJson, _ = json.DecodeString(`{"schemaVersion":2,"manifests":[]}`)
+	assert.Equal(MediaTypeOciIndex, getManifestMediaType(manifestJson))
+	assert.True(isIndexManifest(manifestJson))
+}
+
+func TestMediaTypeAccepted(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(IsMediaTypeAccepted([]string{}, MediaTypeOciIndex))
+	assert.True(IsMediaTypeAccepted([]string{"*/*"}, MediaTypeOciIndex))
+	assert.True(IsMediaTypeAccepted([]string{"application/*"}, MediaTypeOciIndex))
+	assert.True(IsMediaTypeAccepted([]string{MediaTypeOciManifest, MediaTypeOciIndex}, MediaTypeOciIndex))
+	assert.False(IsMediaTypeAccepted([]string{MediaTypeDockerManifest}, MediaTypeOciIndex))
+	assert.False(IsMediaTypeAccepted([]string{"text/*"}, MediaTypeOciIndex))
+}
+
+func TestValidateManifest(t *testing.T) {
+	assert := assert.New(t)
+
+	useTestConfig(t, "")
+
+	configDigest := "sha256:2279fc1f015f997d179c41693a6903e195f012a8dbe390d15bbc2f292b2da996"
+	layerDigest := "sha256:c1750d1ba7eee65531954c1bf99de57dd7ddecc6d1362535f434eb2d2261a0d2"
+	fn := getBlobStoreFilename(configDigest)
+	err := writeBytes(fn, []byte("{}"))
+	assert.Nil(err)
+	assert.Nil(linkBlob("app", configDigest))
+
+	invalid := []string{
+		`{"schemaVersion":1,"name":"app","tag":"1.0","fsLayers":[]}`,
+		`{"schemaVersion":2,"mediaType":"application/json","config":{},"layers":[]}`,
+		`{"schemaVersion":2,"layers":[]}`,
+		`{"schemaVersion":2,"config":{"digest":"sha256:1234"},"layers":[]}`,
+		`{"schemaVersion":2,"manifests":{}}`,
+	}
+	for _, manifest := range invalid {
+		manifestJson, _ := json.DecodeString(manifest)
+		assert.ErrorIs(validateManifest("app", manifestJson), ErrManifestInvalid, manifest)
+	}
+
+	manifestJson, _ := json.DecodeString(`{"schemaVersion":2,"config":{"digest":"` + configDigest + `"},"layers":[]}`)
+	assert.Nil(validateManifest("app", manifestJson))
+
+	manifestJson, _ = json.DecodeString(`{"schemaVersion":2,"config":{"digest":"` + configDigest + `"},"layers":[{"digest":"` + layerDigest + `"}]}`)
+	var blobUnknown *ManifestBlobUnknownError
+	assert.True(errors.As(validateManifest("app", manifestJson), &blobUnknown))
+	assert.Equal([]string{layerDigest}, blobUnknown.Digests)
+
+	manifestJson, _ = json.DecodeString(`{"schemaVersion":2,"config":{"digest":"` + configDigest + `"},"layers":[{"digest":"` + layerDigest + `","urls":["https://example.com/layer"]}]}`)
+	assert.Nil(validateManifest("app", manifestJson))
+
+	manifestJson, _ = json.DecodeString(`{"schemaVersion":2,"manifests":[{"digest":"` + layerDigest + `"}]}`)
+	assert.ErrorIs(validateManifest("app", manifestJson), ErrManifestBlobUnknown)
+}
@@ -0,0 +1,186 @@
+package repo
+
+import (
+	"errors"
+	"io/fs"
+	"mosi-docker-registry/pkg/logging"
+	"mosi-docker-registry/pkg/storage"
+	"path"
+)
+
+// Converts the repository of an older Mosi version to the current layout
+func Migrate() {
+	imgs, err := getImages()
+	if err != nil {
+		logging.Error(LOG, "migration failed to get images")
+		return
+	}
+	// current versions index referrers on push, so the manifests are indexed once
+	referrersFn := getMigrationFilename("referrers")
+	indexReferrers := !exists(referrersFn)
+	referrersIndexed := true
+	for _, img := range imgs {
+		err = migrateBlobs(img)
+		if err != nil {
+			logging.Error(LOG, "migration failed to move blobs of image %s into the blob store: %s", img, err.Error())
+		}
+		err = migrateTags(img)
+		if err != nil {
+			logging.Error(LOG, "migration failed to migrate tags of image %s: %s", img, err.Error())
+		}
+		if indexReferrers {
+			err = migrateReferrers(img)
+			if err != nil {
+				logging.Error(LOG, "migration failed to index referrers of image %s: %s", img, err.Error())
+				referrersIndexed = false
+			}
+		}
+	}
+	if indexReferrers && referrersIndexed {
+		err = writeBytes(referrersFn, []byte{})
+		if err != nil {
+			logging.Error(LOG, "migration failed to mark referrers as indexed: %s", err.Error())
+		}
+	}
+}
+
+// Older versions stored the blobs of each image in v2/imagename/blobs/digest.
+// They are moved into the blob store and replaced by links, blobs the store already has are dropped.
+func migrateBlobs(img string) error {
+	dir := getBlobLinksDir(img)
+	fns, err := listDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, fn := range fns {
+		digest := fn2digest(fn)
+		blobFn := storage.Join(dir, fn)
+		storeFn := getBlobStoreFilename(digest)
+
+		if exists(storeFn) {
+			// an empty file is a link, unless it is an empty blob which is not in the store yet
+			blobSize, err := size(blobFn)
+			if err != nil {
+				return err
+			}
+			if blobSize == 0 {
+				continue
+			}
+			err = deleteFile(blobFn)
+			if err != nil {
+				return err
+			}
+		} else {
+			blobSize, err := size(blobFn)
+			if err != nil {
+				return err
+			}
+			if blobSize == 0 && digest != emptyBlobDigest {
+				logging.Warn(LOG, "migration found link %s of %s without blob", digest, img)
+				continue
+			}
+			err = store().Move(blobFn, storeFn)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = linkBlob(img, digest)
+		if err != nil {
+			return err
+		}
+		logging.Info(LOG, "migrated blob %s of %s into the blob store", digest, img)
+	}
+	return nil
+}
+
+// Older versions stored manifests in tag directories v2/imagename/manifests/tag/digest.
+// Manifests which were pushed by digest got stored in a tag directory named after the digest.
+func migrateTags(img string) error {
+	dir := servedPath(img, "manifests")
+	if !isDir(dir) {
+		return nil
+	}
+	fns, err := listDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, fn := range fns {
+		tagDir := storage.Join(dir, fn)
+		if !isDir(tagDir) {
+			continue
+		}
+
+		manifestFns, err := listDir(tagDir)
+		if err != nil {
+			return err
+		}
+		if len(manifestFns) == 0 {
+			logging.Info(LOG, "migration deleting empty tag directory %s", tagDir)
+			err = deleteFile(tagDir)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		manifestFn := manifestFns[0]
+
+		digest := fn2digest(manifestFn)
+		revisionFn := getManifestRevisionFilename(img, digest)
+		if !exists(revisionFn) {
+			content, err := readBytes(storage.Join(tagDir, manifestFn))
+			if err != nil {
+				return err
+			}
+			err = writeBytes(revisionFn, content)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = deleteFile(tagDir)
+		if err != nil {
+			return err
+		}
+
+		if isDigestReference(fn) {
+			logging.Info(LOG, "migrated manifest %s@%s", img, digest)
+			continue
+		}
+		err = writeTag(img, fn, digest)
+		if err != nil {
+			return err
+		}
+		logging.Info(LOG, "migrated tag %s:%s", img, fn)
+	}
+	return nil
+}
+
+// Older versions did not index manifests with a subject
+func migrateReferrers(img string) error {
+	manifestFns, err := getManifestFiles(img)
+	if err != nil {
+		return err
+	}
+	for _, fn := range manifestFns {
+		manifestJson, err := readJson(fn)
+		if err != nil {
+			continue
+		}
+		err = indexReferrer(img, fn2digest(path.Base(fn)), manifestJson)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrations/name marks a migration as done
+func getMigrationFilename(name string) string {
+	return storage.Join("migrations", name)
+}
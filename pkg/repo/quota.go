@@ -0,0 +1,362 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mosi-docker-registry/pkg/config"
+	"mosi-docker-registry/pkg/filesys"
+	"mosi-docker-registry/pkg/wildcard"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Quotas limit the combined size of the images matching a pattern of the config, or of the images an account may push to.
+// The usage counts each stored blob the images link once, plus the bytes of their upload sessions.
+// The blob links and upload bytes of each image are cached until a change of the image drops them.
+
+// Tells which quota a push exceeds, its usage includes the bytes of the rejected push
+type QuotaExceededError struct {
+	Name  string
+	Usage int64
+	Limit int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s: quota of %s, %s of %s used", ErrDenied.Error(), e.Name, filesys.Bytes2IEC(e.Usage), filesys.Bytes2IEC(e.Limit))
+}
+
+func (e *QuotaExceededError) Unwrap() error {
+	return ErrDenied
+}
+
+type quotaUsage struct {
+	name  string
+	usage int64
+	limit int64
+	blobs map[string]bool
+}
+
+type quotaReader struct {
+	reader io.Reader
+	quotas []quotaUsage
+	read   int64
+}
+
+// Fails with a QuotaExceededError as soon as the bytes read exceed a quota
+func (r *quotaReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	for _, quota := range r.quotas {
+		if quota.usage+r.read > quota.limit {
+			return n, &QuotaExceededError{Name: quota.name, Usage: quota.usage + r.read, Limit: quota.limit}
+		}
+	}
+	return n, err
+}
+
+// the size of a stored blob never changes, its digest is its content
+var storedBlobSizes = map[string]int64{}
+var storedBlobSizesMutex sync.Mutex
+
+type imageUsage struct {
+	blobDigests []string
+	uploadsSize int64
+}
+
+// imageUsagesVersion counts the changes, a usage read during a change is not cached
+var imageUsages = map[string]imageUsage{}
+var imageUsagesVersion = 0
+var imageUsagesMutex sync.Mutex
+
+// Returns a reader which fails when the pushed bytes exceed a quota of the image or of the account,
+// or the QuotaExceededError right away if a quota is used up
+func QuotaReader(img, usr string, reader io.Reader) (io.Reader, error) {
+	quotas, err := getQuotaUsages(img, usr)
+	if err != nil {
+		return nil, err
+	}
+	if len(quotas) == 0 {
+		return reader, nil
+	}
+	for _, quota := range quotas {
+		if quota.usage >= quota.limit {
+			return nil, &QuotaExceededError{Name: quota.name, Usage: quota.usage, Limit: quota.limit}
+		}
+	}
+	return &quotaReader{reader: reader, quotas: quotas}, nil
+}
+
+// Fails with a QuotaExceededError if linking the stored blob to the image exceeds a quota of the image or of the account.
+// A quota whose images link the blob already does not grow.
+func checkBlobQuota(img, usr, digest string, blobSize int64) error {
+	quotas, err := getQuotaUsages(img, usr)
+	if err != nil {
+		return err
+	}
+	for _, quota := range quotas {
+		if quota.blobs[digest] {
+			continue
+		}
+		if quota.usage+blobSize > quota.limit {
+			return &QuotaExceededError{Name: quota.name, Usage: quota.usage + blobSize, Limit: quota.limit}
+		}
+	}
+	return nil
+}
+
+// Returns the quotas which apply to a push of the account to the image
+func getQuotaUsages(img, usr string) ([]quotaUsage, error) {
+	quotas := []quotaUsage{}
+	patternGroups := [][]string{}
+
+	patterns := []string{}
+	for pattern := range config.RepoQuotas() {
+		if wildcard.Matches(img, pattern) {
+			patterns = append(patterns, pattern)
+		}
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		quotas = append(quotas, quotaUsage{name: pattern, limit: config.RepoQuotas()[pattern]})
+		patternGroups = append(patternGroups, []string{pattern})
+	}
+
+	limit, accountPatterns := config.AccountQuota(usr)
+	if limit > 0 {
+		quotas = append(quotas, quotaUsage{name: "account " + usr, limit: limit})
+		patternGroups = append(patternGroups, accountPatterns)
+	}
+
+	if len(quotas) == 0 {
+		return quotas, nil
+	}
+	usages, blobs, err := getUsages(patternGroups)
+	if err != nil {
+		return nil, err
+	}
+	for i := range quotas {
+		quotas[i].usage = usages[i]
+		quotas[i].blobs = blobs[i]
+	}
+	return quotas, nil
+}
+
+// Returns the bytes used by the images matching any of the patterns
+func getUsage(patterns []string) (int64, error) {
+	usages, _, err := getUsages([][]string{patterns})
+	if err != nil {
+		return 0, err
+	}
+	return usages[0], nil
+}
+
+// Returns the bytes used by the images matching any pattern of each group and the blobs they link, reading each image once
+func getUsages(patternGroups [][]string) ([]int64, []map[string]bool, error) {
+	patterns := []string{}
+	for _, group := range patternGroups {
+		patterns = append(patterns, group...)
+	}
+	imgs, err := getQuotaImages(patterns)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	usages := make([]int64, len(patternGroups))
+	blobs := make([]map[string]bool, len(patternGroups))
+	for i := range blobs {
+		blobs[i] = map[string]bool{}
+	}
+	for _, img := range imgs {
+		groups := []int{}
+		for i, patterns := range patternGroups {
+			if matchesAny(img, patterns) {
+				groups = append(groups, i)
+			}
+		}
+		if len(groups) == 0 {
+			continue
+		}
+
+		usage, err := getImageUsage(img)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, i := range groups {
+			for _, blobDigest := range usage.blobDigests {
+				if blobs[i][blobDigest] {
+					continue
+				}
+				blobs[i][blobDigest] = true
+				usages[i] += getCachedBlobSize(blobDigest)
+			}
+			usages[i] += usage.uploadsSize
+		}
+	}
+	return usages, blobs, nil
+}
+
+// Returns the images which may match the patterns.
+// Only the namespace before the first wildcard is listed, e.g. team of team/*, a pattern starting with a wildcard lists all images.
+func getQuotaImages(patterns []string) ([]string, error) {
+	namespaces := map[string]bool{}
+	for _, pattern := range patterns {
+		namespace, _, _ := strings.Cut(pattern, "*")
+		i := strings.LastIndex(namespace, "/")
+		if i <= 0 {
+			return getImages()
+		}
+		namespaces[namespace[:i]] = true
+	}
+
+	imgs := []string{}
+	found := map[string]bool{}
+	for namespace := range namespaces {
+		namespaceImgs, err := findImages(servedPath(namespace), namespace+"/")
+		if err != nil {
+			return nil, err
+		}
+		for _, img := range namespaceImgs {
+			if !found[img] {
+				found[img] = true
+				imgs = append(imgs, img)
+			}
+		}
+	}
+	return imgs, nil
+}
+
+// Returns the blobs an image links and the bytes of its upload sessions, cached until forgetImageUsage
+func getImageUsage(img string) (imageUsage, error) {
+	imageUsagesMutex.Lock()
+	usage, ok := imageUsages[img]
+	version := imageUsagesVersion
+	imageUsagesMutex.Unlock()
+	if ok {
+		return usage, nil
+	}
+
+	blobDigests, err := getImageBlobDigests(img)
+	if err != nil {
+		return usage, err
+	}
+	uploadsSize, err := getUploadsSize(img)
+	if err != nil {
+		return usage, err
+	}
+	usage = imageUsage{blobDigests: blobDigests, uploadsSize: uploadsSize}
+
+	imageUsagesMutex.Lock()
+	if version == imageUsagesVersion {
+		imageUsages[img] = usage
+	}
+	imageUsagesMutex.Unlock()
+	return usage, nil
+}
+
+// Drops the cached usage of an image whose blob links or upload sessions changed
+func forgetImageUsage(img string) {
+	imageUsagesMutex.Lock()
+	delete(imageUsages, img)
+	imageUsagesVersion++
+	imageUsagesMutex.Unlock()
+}
+
+// Drops the cached usages of all images, e.g. of the previous storage driver
+func forgetImageUsages() {
+	imageUsagesMutex.Lock()
+	imageUsages = map[string]imageUsage{}
+	imageUsagesVersion++
+	imageUsagesMutex.Unlock()
+}
+
+// Returns the bytes of the upload sessions of an image
+func getUploadsSize(img string) (int64, error) {
+	uploadsDir := getBlobUploadsDir(img)
+	uploadUuids, err := listDir(uploadsDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+	var uploadsSize int64 = 0
+	for _, uploadUuid := range uploadUuids {
+		uploadSize, err := size(servedPath(img, "uploads", uploadUuid))
+		if err == nil {
+			uploadsSize += uploadSize
+		}
+	}
+	return uploadsSize, nil
+}
+
+func getCachedBlobSize(digest string) int64 {
+	storedBlobSizesMutex.Lock()
+	blobSize, ok := storedBlobSizes[digest]
+	storedBlobSizesMutex.Unlock()
+	if ok {
+		return blobSize
+	}
+
+	storeFn := getBlobStoreFilename(digest)
+	blobSize, err := size(storeFn)
+	if err != nil {
+		// not stored yet or deleted meanwhile, an unlinked blob does not count
+		return 0
+	}
+
+	storedBlobSizesMutex.Lock()
+	storedBlobSizes[digest] = blobSize
+	storedBlobSizesMutex.Unlock()
+	return blobSize
+}
+
+// Drops the size of a blob deleted from the store
+func forgetBlobSize(digest string) {
+	storedBlobSizesMutex.Lock()
+	delete(storedBlobSizes, digest)
+	storedBlobSizesMutex.Unlock()
+}
+
+// Returns the "usage / limit" of the tightest quota of the image, "" if no quota of the config applies.
+// usages caches the usage of each pattern for the next images, the first call gets the usages of all patterns at once.
+func getImageQuota(img string, usages map[string]int64) (string, error) {
+	quotas := config.RepoQuotas()
+	if len(usages) == 0 && len(quotas) > 0 {
+		patterns := []string{}
+		patternGroups := [][]string{}
+		for pattern := range quotas {
+			patterns = append(patterns, pattern)
+			patternGroups = append(patternGroups, []string{pattern})
+		}
+		patternUsages, _, err := getUsages(patternGroups)
+		if err != nil {
+			return "", err
+		}
+		for i, pattern := range patterns {
+			usages[pattern] = patternUsages[i]
+		}
+	}
+
+	quota := ""
+	var free int64 = 0
+	for pattern, limit := range quotas {
+		if !wildcard.Matches(img, pattern) {
+			continue
+		}
+		usage := usages[pattern]
+		if quota == "" || limit-usage < free {
+			free = limit - usage
+			quota = filesys.Bytes2IEC(usage) + " / " + filesys.Bytes2IEC(limit)
+		}
+	}
+	return quota, nil
+}
+
+func matchesAny(img string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if wildcard.Matches(img, pattern) {
+			return true
+		}
+	}
+	return false
+}
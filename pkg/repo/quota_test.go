@@ -0,0 +1,141 @@
+package repo
+
+import (
+	"bytes"
+	"io"
+	"mosi-docker-registry/pkg/filesys"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuota(t *testing.T) {
+	assert := assert.New(t)
+
+	useTestConfig(t, `{
+		"repo": {"dir": "repo", "quotas": [{"name": "team/*", "maxSizeMiB": 1}]},
+		"accounts": [{"usr": "ci", "pwd": "ci", "admin": false, "quotaMiB": 2, "images": [{"name": "ci/*", "push": true}]}]
+	}`)
+
+	pushBlob := func(img string, content []byte) error {
+		reader, err := QuotaReader(img, "ci", bytes.NewReader(content))
+		if err != nil {
+			return err
+		}
+		_, err = uploadTestBlob(img, content, reader)
+		return err
+	}
+
+	layer := bytes.Repeat([]byte("a"), 400*1024)
+	assert.Nil(pushBlob("team/app", layer))
+	// the blob is stored once, a second image linking it does not add to the usage
+	assert.Nil(pushBlob("team/web", layer))
+
+	err := pushBlob("team/app", bytes.Repeat([]byte("b"), 700*1024))
+	var quotaExceeded *QuotaExceededError
+	assert.ErrorAs(err, &quotaExceeded)
+	assert.ErrorIs(err, ErrDenied)
+	assert.Equal("team/*", quotaExceeded.Name)
+	assert.Equal(int64(1024*1024), quotaExceeded.Limit)
+	assert.Greater(quotaExceeded.Usage, quotaExceeded.Limit)
+
+	// the rejected chunk is discarded
+	usage, err := getUsage([]string{"team/*"})
+	assert.Nil(err)
+	assert.Equal(int64(400*1024), usage)
+
+	// the account quota covers the images the account may push to
+	assert.Nil(pushBlob("ci/app", bytes.Repeat([]byte("c"), 1024*1024)))
+	assert.Nil(pushBlob("ci/web", bytes.Repeat([]byte("d"), 1024*1024)))
+	_, err = QuotaReader("ci/app", "ci", bytes.NewReader([]byte{}))
+	assert.ErrorAs(err, &quotaExceeded)
+	assert.Equal("account ci", quotaExceeded.Name)
+
+	reader, err := QuotaReader("other", "admin", bytes.NewReader(layer))
+	assert.Nil(err)
+	data, err := io.ReadAll(reader)
+	assert.Nil(err)
+	assert.Len(data, len(layer))
+
+	res, err := List("team/*", "")
+	assert.Nil(err)
+	table := res.GetArray("tables", nil).GetObject(0, nil)
+	assert.Equal("Quota", table.GetArray("fields", nil).GetString(4, ""))
+	assert.Equal("400.0 KiB / 1.0 MiB", table.GetArray("rows", nil).GetArray(0, nil).GetString(4, ""))
+
+	// the size of a deleted blob is dropped from the cache
+	layerDigest, _ := filesys.CreateDigestFromBuffer(layer)
+	assert.Contains(storedBlobSizes, layerDigest)
+	assert.Nil(unlinkBlob("team/app", layerDigest))
+	assert.Nil(unlinkBlob("team/web", layerDigest))
+	assert.NotContains(storedBlobSizes, layerDigest)
+}
+
+func TestMountQuota(t *testing.T) {
+	assert := assert.New(t)
+
+	useTestConfig(t, `{"repo": {"dir": "repo", "quotas": [{"name": "team/*", "maxSizeMiB": 1}]}}`)
+	r := httptest.NewRequest("POST", "/v2/team/app/blobs/uploads/", nil)
+
+	small := pushTestBlob(t, "other/app", bytes.Repeat([]byte("a"), 400*1024))
+	large := pushTestBlob(t, "other/app", bytes.Repeat([]byte("b"), 700*1024))
+
+	_, _, err := MountBlob("team/app", "other/app", small, "", r)
+	assert.Nil(err)
+	// the mounted blob counts like a pushed one
+	_, _, err = MountBlob("team/web", "other/app", large, "", r)
+	var quotaExceeded *QuotaExceededError
+	assert.ErrorAs(err, &quotaExceeded)
+	assert.ErrorIs(err, ErrDenied)
+	assert.Equal("team/*", quotaExceeded.Name)
+	_, err = getLinkedBlobFilename("team/web", large)
+	assert.ErrorIs(err, ErrBlobUnknown)
+
+	// a blob the images of the quota link already fits
+	_, _, err = MountBlob("team/web", "team/app", small, "", r)
+	assert.Nil(err)
+	usage, err := getUsage([]string{"team/*"})
+	assert.Nil(err)
+	assert.Equal(int64(400*1024), usage)
+}
+
+func TestQuotaUsageCache(t *testing.T) {
+	assert := assert.New(t)
+
+	useTestConfig(t, `{"repo": {"dir": "repo", "quotas": [{"name": "team/*", "maxSizeMiB": 1}]}}`)
+
+	pushTestBlob(t, "team/app", bytes.Repeat([]byte("a"), 400*1024))
+	pushTestBlob(t, "other/app", bytes.Repeat([]byte("b"), 400*1024))
+	usage, err := getUsage([]string{"team/*"})
+	assert.Nil(err)
+	assert.Equal(int64(400*1024), usage)
+	// only the namespace of the pattern is read
+	assert.Contains(imageUsages, "team/app")
+	assert.NotContains(imageUsages, "other/app")
+
+	// a push and a delete drop the cached usage of the image
+	digest := pushTestBlob(t, "team/app", bytes.Repeat([]byte("c"), 100*1024))
+	assert.NotContains(imageUsages, "team/app")
+	usage, err = getUsage([]string{"team/*"})
+	assert.Nil(err)
+	assert.Equal(int64(500*1024), usage)
+
+	assert.Nil(unlinkBlob("team/app", digest))
+	usage, err = getUsage([]string{"team/*"})
+	assert.Nil(err)
+	assert.Equal(int64(400*1024), usage)
+
+	uploadUuid, err := CreateBlobUpload("team/app")
+	assert.Nil(err)
+	_, err = UploadBlobChunk("team/app", uploadUuid, -1, -1, bytes.NewReader(bytes.Repeat([]byte("d"), 100*1024)))
+	assert.Nil(err)
+	usage, err = getUsage([]string{"team/*"})
+	assert.Nil(err)
+	assert.Equal(int64(500*1024), usage)
+
+	assert.Nil(CancelBlobUpload("team/app", uploadUuid))
+	usage, err = getUsage([]string{"team/*"})
+	assert.Nil(err)
+	assert.Equal(int64(400*1024), usage)
+}
@@ -0,0 +1,61 @@
+package repo
+
+import (
+	"errors"
+	"io/fs"
+	"mosi-docker-registry/pkg/logging"
+)
+
+// Tags and manifests are replaced atomically by the storage driver, so a tag refers to either the old or the new manifest.
+// A crash may still leave the temporary files of interrupted writes, and tags of older versions which were not written atomically.
+
+// Repairs the repository after a crash, before the server accepts requests
+func Recover() {
+	err := store().Recover()
+	if err != nil {
+		logging.Error(LOG, "recovery failed to remove interrupted writes: %s", err.Error())
+	}
+
+	imgs, err := getImages()
+	if err != nil {
+		logging.Error(LOG, "recovery failed to get images")
+		return
+	}
+	for _, img := range imgs {
+		err = recoverTags(img)
+		if err != nil {
+			logging.Error(LOG, "recovery failed to check tags of image %s: %s", img, err.Error())
+		}
+	}
+}
+
+// Deletes tags which do not refer to a manifest, e.g. an empty tag file, so they do not resolve to a broken manifest
+func recoverTags(img string) error {
+	tags, err := getImageTags(img)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		digest, err := getTagDigest(img, tag)
+		if err != nil {
+			return err
+		}
+		if isValidDigest(digest) {
+			fn := getManifestRevisionFilename(img, digest)
+			if exists(fn) {
+				continue
+			}
+		}
+
+		logging.Warn(LOG, "recovery deleting tag %s:%s of missing manifest '%s'", img, tag, digest)
+		fn := getTagFilename(img, tag)
+		err = deleteFile(fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package repo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecoverTags(t *testing.T) {
+	assert := assert.New(t)
+
+	useTestConfig(t, "")
+
+	digest := "sha256:2279fc1f015f997d179c41693a6903e195f012a8dbe390d15bbc2f292b2da996"
+	fn := getManifestRevisionFilename("app", digest)
+	assert.Nil(writeBytes(fn, []byte("{}")))
+	assert.Nil(writeTag("app", "latest", digest))
+	assert.Nil(writeTag("app", "broken", ""))
+	assert.Nil(writeTag("app", "dangling", "sha256:c1750d1ba7eee65531954c1bf99de57dd7ddecc6d1362535f434eb2d2261a0d2"))
+
+	Recover()
+
+	tags, err := getImageTags("app")
+	assert.Nil(err)
+	assert.Equal([]string{"latest"}, tags)
+	tagDigest, err := resolveManifest("app", "latest")
+	assert.Nil(err)
+	assert.Equal(digest, tagDigest)
+}
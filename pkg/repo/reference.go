@@ -0,0 +1,137 @@
+package repo
+
+import (
+	"errors"
+	"io/fs"
+	"regexp"
+	"strings"
+)
+
+// A manifest reference is either a tag or a digest, e.g.
+// /v2/imagename/manifests/latest
+// /v2/imagename/manifests/sha256:2279fc1f015f997d179c41693a6903e195f012a8dbe390d15bbc2f292b2da996
+//
+// Manifests are stored by digest in v2/imagename/revisions/digest.
+// Tags are files v2/imagename/manifests/tag containing the digest of the tagged manifest.
+
+var tagRegexp = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+var digestRegexp = regexp.MustCompile(`^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-zA-Z0-9=_-]+$`)
+var sha256Regexp = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// Tags must not contain ':'
+func isDigestReference(reference string) bool {
+	return strings.Contains(reference, ":")
+}
+
+func isValidTag(tag string) bool {
+	return tagRegexp.MatchString(tag)
+}
+
+func isValidDigest(digest string) bool {
+	if strings.HasPrefix(digest, "sha256:") {
+		return sha256Regexp.MatchString(digest)
+	}
+	return digestRegexp.MatchString(digest)
+}
+
+func checkReference(reference string) error {
+	if isDigestReference(reference) {
+		if !isValidDigest(reference) {
+			return ErrDigestInvalid
+		}
+	} else if !isValidTag(reference) {
+		return ErrTagInvalid
+	}
+	return nil
+}
+
+// Returns the digest of the manifest a tag or digest refers to, fs.ErrNotExist if there is no such manifest
+func resolveManifest(img, reference string) (string, error) {
+	if checkReference(reference) != nil {
+		return "", fs.ErrNotExist
+	}
+	if !isDigestReference(reference) {
+		return getTagDigest(img, reference)
+	}
+	fn := getManifestRevisionFilename(img, reference)
+	if !exists(fn) {
+		return "", fs.ErrNotExist
+	}
+	return reference, nil
+}
+
+func getTagDigest(img, tag string) (string, error) {
+	fn := getTagFilename(img, tag)
+	b, err := readBytes(fn)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func writeTag(img, tag, digest string) error {
+	fn := getTagFilename(img, tag)
+	return writeBytes(fn, []byte(digest))
+}
+
+// Returns the tags referring to a manifest
+func getManifestTags(img, digest string) ([]string, error) {
+	tags, err := getImageTags(img)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	manifestTags := []string{}
+	for _, tag := range tags {
+		tagDigest, err := getTagDigest(img, tag)
+		if err != nil {
+			return nil, err
+		}
+		if tagDigest == digest {
+			manifestTags = append(manifestTags, tag)
+		}
+	}
+	return manifestTags, nil
+}
+
+// Returns true if a manifest is tagged, referenced by a manifest list or image index or refers to an existing subject
+func isManifestReferenced(img, digest string) (bool, error) {
+	tags, err := getManifestTags(img, digest)
+	if err != nil {
+		return false, err
+	}
+	if len(tags) > 0 {
+		return true, nil
+	}
+
+	manifestJson, err := getManifestJson(img, digest)
+	if err == nil {
+		alive, err := isSubjectAlive(img, manifestJson)
+		if err != nil || alive {
+			return alive, err
+		}
+	}
+
+	manifestFns, err := getManifestFiles(img)
+	if err != nil {
+		return false, err
+	}
+	for _, fn := range manifestFns {
+		manifestJson, err := readJson(fn)
+		if err != nil || !isIndexManifest(manifestJson) {
+			continue
+		}
+		childDigests, err := getIndexManifestDigests(manifestJson)
+		if err != nil {
+			continue
+		}
+		for _, childDigest := range childDigests {
+			if childDigest == digest {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,21 @@
+package repo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReference(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(checkReference("latest"))
+	assert.Nil(checkReference("1.0.0-rc_1"))
+	assert.Nil(checkReference("sha256:2279fc1f015f997d179c41693a6903e195f012a8dbe390d15bbc2f292b2da996"))
+
+	assert.ErrorIs(checkReference(""), ErrTagInvalid)
+	assert.ErrorIs(checkReference(".."), ErrTagInvalid)
+	assert.ErrorIs(checkReference("-latest"), ErrTagInvalid)
+	assert.ErrorIs(checkReference("sha256:2279fc1f"), ErrDigestInvalid)
+	assert.ErrorIs(checkReference("sha256:../../etc"), ErrDigestInvalid)
+}
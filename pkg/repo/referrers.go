@@ -0,0 +1,156 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"mosi-docker-registry/pkg/json"
+	"mosi-docker-registry/pkg/logging"
+	"mosi-docker-registry/pkg/storage"
+	"sort"
+)
+
+// Manifests with a subject field refer to another manifest, e.g. signatures, SBOMs and attestations of an image.
+// They are indexed as empty files v2/imagename/referrers/subject/digest.
+// A referrer may be pushed before its subject and stays alive as long as its subject exists.
+
+// Returns the digest of the manifest's subject, "" if it has none
+func getManifestSubject(manifestJson *json.JsonObject) string {
+	if subject := manifestJson.GetObject("subject", nil); subject != nil {
+		return subject.GetString("digest", "")
+	}
+	return ""
+}
+
+// Returns the digest of the subject of the manifest content, "" if it has none
+func GetManifestSubject(content []byte) string {
+	manifestJson, err := json.DecodeBytes(content)
+	if err != nil {
+		return ""
+	}
+	return getManifestSubject(manifestJson)
+}
+
+// The artifact type of a manifest is its artifactType field, otherwise the media type of its config
+func getManifestArtifactType(manifestJson *json.JsonObject) string {
+	if artifactType := manifestJson.GetString("artifactType", ""); len(artifactType) > 0 {
+		return artifactType
+	}
+	if config := manifestJson.GetObject("config", nil); config != nil {
+		return config.GetString("mediaType", "")
+	}
+	return ""
+}
+
+// Returns the referrers of subject as an image index, optionally filtered by artifactType
+func GetReferrers(img, subject, artifactType string) (*json.JsonObject, error) {
+	if !isValidDigest(subject) {
+		return nil, fmt.Errorf("%w: %s", ErrDigestInvalid, subject)
+	}
+
+	// an unknown image has no referrers either, the spec wants an empty index then
+	digests, err := getReferrerDigests(img, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := json.NewJsonArray(0)
+	for _, digest := range digests {
+		fn := getManifestRevisionFilename(img, digest)
+		content, err := readBytes(fn)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		manifestJson, err := json.DecodeBytes(content)
+		if err != nil {
+			logging.Error(LOG, "failed to decode referrer manifest %s@%s", img, digest)
+			continue
+		}
+		if len(artifactType) > 0 && getManifestArtifactType(manifestJson) != artifactType {
+			continue
+		}
+
+		descriptor := json.NewJsonObject()
+		descriptor.Put("mediaType", getManifestMediaType(manifestJson))
+		descriptor.Put("digest", digest)
+		descriptor.Put("size", len(content))
+		if artifactType := getManifestArtifactType(manifestJson); len(artifactType) > 0 {
+			descriptor.Put("artifactType", artifactType)
+		}
+		if annotations := manifestJson.GetObject("annotations", nil); annotations != nil {
+			descriptor.Put("annotations", annotations)
+		}
+		manifests.Add(descriptor)
+	}
+
+	index := json.NewJsonObject()
+	index.Put("schemaVersion", 2)
+	index.Put("mediaType", MediaTypeOciIndex)
+	index.Put("manifests", manifests)
+	return index, nil
+}
+
+// Adds a manifest to the referrers of its subject
+func indexReferrer(img, digest string, manifestJson *json.JsonObject) error {
+	subject := getManifestSubject(manifestJson)
+	if !isValidDigest(subject) {
+		return nil
+	}
+	fn := getReferrerFilename(img, subject, digest)
+	return writeBytes(fn, []byte{})
+}
+
+// Removes a deleted manifest from the referrers of its subject
+func unindexReferrer(img, digest string, manifestJson *json.JsonObject) error {
+	subject := getManifestSubject(manifestJson)
+	if !isValidDigest(subject) {
+		return nil
+	}
+	fn := getReferrerFilename(img, subject, digest)
+	err := deleteFile(fn)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Returns true if the manifest refers to a subject which exists
+func isSubjectAlive(img string, manifestJson *json.JsonObject) (bool, error) {
+	subject := getManifestSubject(manifestJson)
+	if !isValidDigest(subject) {
+		return false, nil
+	}
+	fn := getManifestRevisionFilename(img, subject)
+	return exists(fn), nil
+}
+
+// Returns the sorted digests of the manifests referring to subject
+func getReferrerDigests(img, subject string) ([]string, error) {
+	dir := getReferrersDir(img, subject)
+	fns, err := listDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	digests := make([]string, len(fns))
+	for i, fn := range fns {
+		digests[i] = fn2digest(fn)
+	}
+	sort.Strings(digests)
+	return digests, nil
+}
+
+// v2/imagename/referrers/subject
+func getReferrersDir(img, subject string) string {
+	return servedPath(img, "referrers", digest2fn(subject))
+}
+
+// v2/imagename/referrers/subject/digest
+func getReferrerFilename(img, subject, digest string) string {
+	return storage.Join(getReferrersDir(img, subject), digest2fn(digest))
+}
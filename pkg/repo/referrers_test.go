@@ -0,0 +1,51 @@
+package repo
+
+import (
+	"mosi-docker-registry/pkg/filesys"
+	"mosi-docker-registry/pkg/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReferrerArtifactType(t *testing.T) {
+	assert := assert.New(t)
+
+	subject := "sha256:2279fc1f015f997d179c41693a6903e195f012a8dbe390d15bbc2f292b2da996"
+
+	manifestJson, _ := json.DecodeString(`{"schemaVersion":2,"artifactType":"application/spdx+json","config":{"mediaType":"application/vnd.oci.empty.v1+json"},"layers":[],"subject":{"digest":"` + subject + `"}}`)
+	assert.Equal(subject, getManifestSubject(manifestJson))
+	assert.Equal("application/spdx+json", getManifestArtifactType(manifestJson))
+
+	manifestJson, _ = json.DecodeString(`{"schemaVersion":2,"config":{"mediaType":"application/vnd.dev.cosign.artifact.sig.v1+json"},"layers":[]}`)
+	assert.Equal("", getManifestSubject(manifestJson))
+	assert.Equal("application/vnd.dev.cosign.artifact.sig.v1+json", getManifestArtifactType(manifestJson))
+
+	assert.Equal(subject, GetManifestSubject([]byte(`{"subject":{"digest":"`+subject+`"}}`)))
+	assert.Equal("", GetManifestSubject([]byte(`not json`)))
+}
+
+func TestMigrateReferrers(t *testing.T) {
+	assert := assert.New(t)
+
+	useTestConfig(t, "")
+
+	subject := "sha256:2279fc1f015f997d179c41693a6903e195f012a8dbe390d15bbc2f292b2da996"
+	writeReferrer := func(artifactType string) string {
+		content := []byte(`{"schemaVersion":2,"artifactType":"` + artifactType + `","config":{"mediaType":"application/vnd.oci.empty.v1+json"},"layers":[],"subject":{"digest":"` + subject + `"}}`)
+		digest, _ := filesys.CreateDigestFromBuffer(content)
+		assert.Nil(writeBytes(getManifestRevisionFilename("team/app", digest), content))
+		return digest
+	}
+
+	// an older version stored the referrer without indexing it
+	sbom := writeReferrer("application/spdx+json")
+	Migrate()
+	assert.True(exists(getReferrerFilename("team/app", subject, sbom)))
+	assert.True(exists(getMigrationFilename("referrers")))
+
+	// the manifests are indexed once, not on every start
+	signature := writeReferrer("application/vnd.dev.cosign.artifact.sig.v1+json")
+	Migrate()
+	assert.False(exists(getReferrerFilename("team/app", subject, signature)))
+}
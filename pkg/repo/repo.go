@@ -2,38 +2,74 @@ package repo
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"mosi-docker-registry/pkg/config"
 	"mosi-docker-registry/pkg/filesys"
 	"mosi-docker-registry/pkg/json"
 	"mosi-docker-registry/pkg/logging"
+	"mosi-docker-registry/pkg/storage"
 	"net/http"
-	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 const LOG = "REPO"
 
+// Image directory entries which hold an image's data. Image names must not contain any of them as a component.
+var reservedNames = []string{"manifests", "revisions", "referrers", "blobs", "uploads"}
+
+// A single component of an image name as defined by the distribution spec
+var imageNameComponentRegexp = regexp.MustCompile(`^[a-z0-9]+(?:(?:\.|_|__|-+)[a-z0-9]+)*$`)
+
+// Image names may consist of any number of slash-separated components, e.g. team/project/image
+func IsValidImageName(img string) bool {
+	if len(img) == 0 || len(img) > 255 {
+		return false
+	}
+	for _, component := range strings.Split(img, "/") {
+		if !imageNameComponentRegexp.MatchString(component) || isReservedName(component) {
+			return false
+		}
+	}
+	return true
+}
+
+func isReservedName(name string) bool {
+	for _, reservedName := range reservedNames {
+		if name == reservedName {
+			return true
+		}
+	}
+	return false
+}
+
 func ExistsBlob(img, digest string) (exists bool, len int64, modified string) {
 	exists = false
 	len = -1
 	modified = ""
 
-	servedFn, err := getBlobServedFilename(img, digest)
+	if !isValidDigest(digest) {
+		return
+	}
+
+	servedFn, err := getLinkedBlobFilename(img, digest)
 	if err != nil {
 		return
 	}
 
-	len, err = filesys.Size(servedFn)
+	len, err = size(servedFn)
 	if err != nil {
 		return
 	}
 
-	modified, err = filesys.ModifiedHttpDate(servedFn)
+	modified, err = modifiedHttpDate(servedFn)
 	if err != nil {
 		return
 	}
@@ -42,9 +78,30 @@ func ExistsBlob(img, digest string) (exists bool, len int64, modified string) {
 	return
 }
 
-func CreateBlobUploadUuid() string {
+// Starts an upload session with an empty upload file
+func CreateBlobUpload(img string) (string, error) {
 	uploadUuid := uuid.New().String()
-	return uploadUuid
+	fn, err := getBlobUploadFilename(img, uploadUuid)
+	if err != nil {
+		return "", err
+	}
+	// a cleanup deleting the image directory must not delete the new session
+	unlock := lockImage(img)
+	defer unlock()
+	return uploadUuid, writeBytes(fn, []byte{})
+}
+
+// Returns the number of bytes an upload session has received so far
+func GetBlobUploadSize(img, uploadUuid string) (int64, error) {
+	fn, err := getBlobUploadFilename(img, uploadUuid)
+	if err != nil {
+		return -1, err
+	}
+	uploadSize, err := size(fn)
+	if errors.Is(err, fs.ErrNotExist) {
+		return -1, fmt.Errorf("%w: %s", ErrBlobUploadUnknown, uploadUuid)
+	}
+	return uploadSize, err
 }
 
 // /v2/imagename/blobs/upload/uploadUuid
@@ -52,12 +109,56 @@ func GetBlobUploadUrlPath(img, uploadUuid string) string {
 	return config.ServerPath() + "/" + img + "/blobs/uploads/" + uploadUuid
 }
 
-func UploadBlob(img, uploadUuid string, reader io.ReadCloser) (int64, error) {
+// Appends a chunk to an upload session and returns the session's new size.
+// A chunk with a range (start >= 0) must continue exactly where the session ends and have end-start+1 bytes,
+// otherwise ErrBlobUploadInvalid is returned together with the unchanged session size.
+func UploadBlobChunk(img, uploadUuid string, start, end int64, reader io.Reader) (size int64, err error) {
+	size, err = GetBlobUploadSize(img, uploadUuid)
+	if err != nil {
+		return
+	}
+
+	if start >= 0 && start != size {
+		err = fmt.Errorf("%w, chunk starts at %d but %d bytes are uploaded", ErrBlobUploadInvalid, start, size)
+		return
+	}
+
 	fn, err := getBlobUploadFilename(img, uploadUuid)
 	if err != nil {
-		return 0, err
+		return
 	}
-	return filesys.CopyOrDelete(reader, fn)
+
+	w, err := store().Writer(fn, true)
+	if errors.Is(err, fs.ErrNotExist) {
+		err = fmt.Errorf("%w: %s", ErrBlobUploadUnknown, uploadUuid)
+		return
+	}
+	if err != nil {
+		return
+	}
+	defer forgetImageUsage(img)
+
+	written, err := io.Copy(w, reader)
+	if err != nil {
+		w.Cancel()
+		return
+	}
+
+	if start >= 0 && written != end-start+1 {
+		err = w.Cancel()
+		if err == nil {
+			err = fmt.Errorf("%w, chunk %d-%d has %d bytes", ErrSizeInvalid, start, end, written)
+		}
+		return
+	}
+
+	err = w.Close()
+	if err != nil {
+		return
+	}
+
+	size += written
+	return
 }
 
 func PutBlob(img, uploadUuid, digest string, r *http.Request) (len int64, uri string, resultDigest string, err error) {
@@ -71,26 +172,76 @@ func PutBlob(img, uploadUuid, digest string, r *http.Request) (len int64, uri st
 		return
 	}
 
-	servedFn, err := getBlobServedFilename(img, digest)
+	resultDigest, err = createDigest(uploadFn)
+	if err != nil || resultDigest != digest {
+		deleteFile(uploadFn)
+		forgetImageUsage(img)
+		if err == nil {
+			err = fmt.Errorf("%w, expected: %s got: %s", ErrDigestInvalid, digest, resultDigest)
+		}
+		return
+	}
+
+	err = storeBlob(img, digest, uploadFn)
 	if err != nil {
 		return
 	}
 
-	resultDigest, err = filesys.CreateDigestFromFile(uploadFn)
-	if err != nil || resultDigest != digest {
-		filesys.DeleteFile(uploadFn)
-		if resultDigest != digest {
-			err = errors.New("digest mismatch, expected: " + digest + " got: " + resultDigest)
-		}
+	servedFn, err := getLinkedBlobFilename(img, digest)
+	if err != nil {
+		return
+	}
+
+	len, err = size(servedFn)
+	if err != nil {
+		return
+	}
+
+	uri = getBlobServedUri(img, digest, r)
+
+	return
+}
+
+// Mounts a blob of fromImg into img without uploading it again.
+// Returns ErrBlobUnknown if fromImg does not have the blob, the client then has to upload it.
+// Fails with a QuotaExceededError if the blob does not fit into a quota of the image or of the account.
+func MountBlob(img, fromImg, digest, usr string, r *http.Request) (len int64, uri string, err error) {
+	len = 0
+	uri = ""
+	err = nil
+
+	if !isValidDigest(digest) {
+		err = fmt.Errorf("%w: %s", ErrDigestInvalid, digest)
+		return
+	}
+
+	if !IsValidImageName(fromImg) {
+		err = fmt.Errorf("%w: %s in %s", ErrBlobUnknown, digest, fromImg)
 		return
 	}
 
-	err = filesys.RenameOrDelete(uploadFn, servedFn)
+	// the blob store already has the blob, the image just needs to link it
+	unlock := lockBlob(digest)
+	defer unlock()
+	servedFn, err := getLinkedBlobFilename(fromImg, digest)
 	if err != nil {
 		return
 	}
 
-	len, err = filesys.Size(servedFn)
+	len, err = size(servedFn)
+	if err != nil {
+		return
+	}
+
+	// a blob the image links already adds nothing to its quotas
+	if _, linkedErr := getLinkedBlobFilename(img, digest); linkedErr != nil {
+		err = checkBlobQuota(img, usr, digest, len)
+		if err != nil {
+			return
+		}
+	}
+
+	err = linkBlob(img, digest)
 	if err != nil {
 		return
 	}
@@ -100,158 +251,376 @@ func PutBlob(img, uploadUuid, digest string, r *http.Request) (len int64, uri st
 	return
 }
 
-func UploadManifest(img, tag string, reader io.ReadCloser) (digest string, modified string, content []byte, err error) {
+// Uploads a manifest by tag or by digest.
+// The manifests referenced by a manifest list or image index are uploaded by digest and get stored as untagged revisions.
+func UploadManifest(img, reference string, reader io.ReadCloser) (digest string, mediaType string, modified string, content []byte, err error) {
 	digest = ""
+	mediaType = ""
 	modified = ""
 	content = nil
 	err = nil
 
-	content, err = io.ReadAll(reader)
+	err = checkReference(reference)
 	if err != nil {
 		return
 	}
 
-	digest, err = filesys.CreateDigestFromBuffer(content)
+	maxSize := config.ManifestMaxSize()
+	if maxSize > 0 {
+		content, err = io.ReadAll(io.LimitReader(reader, maxSize+1))
+	} else {
+		content, err = io.ReadAll(reader)
+	}
+	if err != nil {
+		return
+	}
+	if maxSize > 0 && int64(len(content)) > maxSize {
+		err = fmt.Errorf("%w: exceeds the maximum size of %s", ErrManifestInvalid, filesys.Bytes2IEC(maxSize))
+		return
+	}
+
+	manifestJson, err := json.DecodeBytes(content)
 	if err != nil {
+		err = fmt.Errorf("%w: %s", ErrManifestInvalid, err.Error())
 		return
 	}
+	mediaType = getManifestMediaType(manifestJson)
 
-	servedFn, err := getManifestServedFilename(img, tag, digest)
+	unlock := lockImage(img)
+	defer unlock()
+
+	err = validateManifest(img, manifestJson)
 	if err != nil {
 		return
 	}
 
-	servedDir, err := getManifestServedDir(img, tag)
+	digest, err = filesys.CreateDigestFromBuffer(content)
 	if err != nil {
 		return
 	}
 
-	err = filesys.DeleteDir(servedDir)
+	if isDigestReference(reference) && reference != digest {
+		err = fmt.Errorf("%w, expected: %s got: %s", ErrDigestInvalid, reference, digest)
+		return
+	}
+
+	servedFn := getManifestRevisionFilename(img, digest)
+
+	err = writeBytes(servedFn, content)
 	if err != nil {
 		return
 	}
 
-	_, err = filesys.WriteBytes(servedFn, content)
+	err = indexReferrer(img, digest, manifestJson)
 	if err != nil {
 		return
 	}
 
-	modified, err = filesys.ModifiedHttpDate(servedFn)
+	if !isDigestReference(reference) {
+		err = writeTag(img, reference, digest)
+		if err != nil {
+			return
+		}
+	}
+
+	modified, err = modifiedHttpDate(servedFn)
 	if err != nil {
 		return
 	}
 
-	CleanupImage(img)
+	requestCleanup(img)
 
 	return
 }
 
-func ExistsManifest(img, tag string) (exists bool, len int64, modified string, digest string) {
+func ExistsManifest(img, reference string) (exists bool, len int64, modified string, digest string, mediaType string) {
 	exists = false
 	len = -1
 	modified = ""
 	digest = ""
+	mediaType = ""
 
-	servedDir, err := getManifestServedDir(img, tag)
+	digest, err := resolveManifest(img, reference)
 	if err != nil {
 		return
 	}
 
-	servedFn, err := filesys.GetFirstFilenameInDir(servedDir)
-	if err != nil || servedFn == "" {
-		return
-	}
-	digest = fn2digest(servedFn)
+	servedFn := getManifestRevisionFilename(img, digest)
 
-	servedFn, err = getManifestServedFilename(img, tag, digest)
+	len, err = size(servedFn)
 	if err != nil {
 		return
 	}
 
-	len, err = filesys.Size(servedFn)
+	modified, err = modifiedHttpDate(servedFn)
 	if err != nil {
 		return
 	}
 
-	modified, err = filesys.ModifiedHttpDate(servedFn)
+	manifestJson, err := readJson(servedFn)
 	if err != nil {
 		return
 	}
+	mediaType = getManifestMediaType(manifestJson)
 
 	exists = true
 	return
 }
 
-func DownloadBlob(img, digest string, w http.ResponseWriter) {
-	servedFn, err := getBlobServedFilename(img, digest)
+// Downloads a blob or the requested range of it. The digest is the blob's strong ETag, e.g. for If-Range.
+// Returns an error without responding if the blob cannot be downloaded.
+func DownloadBlob(img, digest string, w http.ResponseWriter, r *http.Request) error {
+	if !isValidDigest(digest) {
+		return fmt.Errorf("%w: %s", ErrDigestInvalid, digest)
+	}
+
+	servedFn, err := getLinkedBlobFilename(img, digest)
 	if err != nil {
-		w.WriteHeader(500)
-		return
+		return err
 	}
 
-	isGzip, err := filesys.IsGzip(servedFn)
+	fileInfo, err := store().Stat(servedFn)
 	if err != nil {
-		logging.Error(LOG, "failed to get blob filetype %s err: %s", servedFn, err.Error())
-		w.WriteHeader(500)
-		return
+		return fmt.Errorf("%w: %s in %s", ErrBlobUnknown, digest, img)
+	}
+	reader, err := store().Reader(servedFn)
+	if err != nil {
+		return fmt.Errorf("%w: %s in %s", ErrBlobUnknown, digest, img)
+	}
+	defer reader.Close()
+
+	isGzip, err := isGzipReader(reader)
+	if err != nil {
+		return fmt.Errorf("failed to get blob filetype %s: %w", servedFn, err)
 	}
 
 	contentType := ""
 	if isGzip {
-		w.Header().Set("Docker-Content-Digest", digest)
 		contentType = "application/vnd.docker.image.rootfs.diff.tar.gzip"
 	} else {
 		contentType = "application/vnd.docker.distribution.manifest.v2+json"
 	}
 
-	err = download(servedFn, contentType, w)
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", GetBlobETag(digest))
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	http.ServeContent(w, r, "", fileInfo.Modified, reader)
+	return nil
+}
+
+// Reads the gzip magic number and rewinds the reader, empty blobs are not gzipped
+func isGzipReader(reader io.ReadSeeker) (bool, error) {
+	b := []byte{0, 0, 0}
+	n, err := io.ReadFull(reader, b)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return false, err
+	}
+	_, err = reader.Seek(0, io.SeekStart)
 	if err != nil {
-		logging.Error(LOG, "failed to download blob %s err: %s", servedFn, err.Error())
+		return false, err
 	}
+	return n == 3 && b[0] == 0x1f && b[1] == 0x8b && b[2] == 0x08, nil
 }
 
-func DownloadManifest(img, digest string, w http.ResponseWriter) {
-	servedFn, err := findManifestServedFilename(img, digest)
+// Blobs never change, so their digest is a strong ETag
+func GetBlobETag(digest string) string {
+	return `"` + digest + `"`
+}
+
+// Downloads a manifest if its media type is one of the accepted media types, otherwise returns ErrManifestNotAccepted.
+// Returns an error without responding if the manifest cannot be downloaded.
+func DownloadManifest(img, reference string, acceptedMediaTypes []string, w http.ResponseWriter) error {
+	err := checkReference(reference)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, reference)
+	}
+	digest, err := resolveManifest(img, reference)
 	if errors.Is(err, fs.ErrNotExist) {
-		logging.Error(LOG, "manifest not exists %s", err.Error())
-		w.WriteHeader(404)
-		return
+		return fmt.Errorf("%w: %s:%s", ErrManifestUnknown, img, reference)
 	}
 	if err != nil {
-		logging.Error(LOG, "failed to find manifest %s", err.Error())
-		w.WriteHeader(500)
-		return
+		return err
 	}
-	err = download(servedFn, "application/vnd.docker.distribution.manifest.v2+json", w)
+	servedFn := getManifestRevisionFilename(img, digest)
+	manifestJson, err := readJson(servedFn)
 	if err != nil {
-		logging.Error(LOG, "failed to download manifest %s err: %s", servedFn, err.Error())
+		return fmt.Errorf("failed to decode manifest %s: %w", servedFn, err)
+	}
+	mediaType := getManifestMediaType(manifestJson)
+	if !IsMediaTypeAccepted(acceptedMediaTypes, mediaType) {
+		return fmt.Errorf("%w: %s", ErrManifestNotAccepted, mediaType)
 	}
+	w.Header().Set("Docker-Content-Digest", digest)
+	return download(servedFn, mediaType, w)
 }
 
+// Fails without responding if fn does not exist
 func download(fn, contentType string, w http.ResponseWriter) error {
-	len, err := filesys.Size(fn)
-	if errors.Is(err, fs.ErrNotExist) {
-		w.WriteHeader(404)
+	reader, err := store().Reader(fn)
+	if err != nil {
 		return err
 	}
+	defer reader.Close()
+	len, err := size(fn)
 	if err != nil {
-		w.WriteHeader(500)
 		return err
 	}
 	w.Header().Set("Content-Type", contentType)
 	w.Header().Set("Content-Length", strconv.FormatInt(len, 10))
 	w.WriteHeader(200)
 
-	_, err = filesys.Copy(fn, w)
-	return err
+	_, err = io.Copy(w, reader)
+	if err != nil {
+		logging.Error(LOG, "failed to download %s err: %s", fn, err.Error())
+	}
+	return nil
 }
 
 func deleteImage(img, tag string) error {
-	dir, err := getManifestServedDir(img, tag)
+	digest, err := getTagDigest(img, tag)
+	if err != nil {
+		return err
+	}
+
+	fn := getTagFilename(img, tag)
+	err = deleteFile(fn)
+	if err != nil {
+		return err
+	}
+
+	return deleteManifestIfUnreferenced(img, digest)
+}
+
+// Deletes a manifest by tag or by digest and cleans up the image afterwards.
+// Deleting a tag deletes the manifest as well, unless it is still tagged or referenced by a manifest list or image index.
+// Deleting a digest deletes the manifest and all tags referring to it.
+func DeleteManifest(img, reference string) error {
+	err := checkReference(reference)
+	if err != nil {
+		return err
+	}
+
+	unlock := lockImage(img)
+	defer unlock()
+
+	digest, err := resolveManifest(img, reference)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s:%s", ErrManifestUnknown, img, reference)
+	}
+	if err != nil {
+		return err
+	}
+
+	if isDigestReference(reference) {
+		err = deleteManifest(img, digest)
+	} else {
+		err = deleteImage(img, reference)
+	}
+	if err != nil {
+		return err
+	}
+
+	requestCleanup(img)
+	return nil
+}
+
+// Deletes a blob and cleans up the image afterwards
+func DeleteBlob(img, digest string) error {
+	if !isValidDigest(digest) {
+		return fmt.Errorf("%w: %s", ErrDigestInvalid, digest)
+	}
+
+	unlock := lockImage(img)
+	defer unlock()
+
+	_, err := getLinkedBlobFilename(img, digest)
+	if err != nil {
+		return err
+	}
+
+	err = unlinkBlob(img, digest)
 	if err != nil {
 		return err
 	}
-	return filesys.DeleteDir(dir)
+
+	requestCleanup(img)
+	return nil
+}
+
+// Deletes a manifest and its tags
+func deleteManifest(img, digest string) error {
+	tags, err := getManifestTags(img, digest)
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		fn := getTagFilename(img, tag)
+		err = deleteFile(fn)
+		if err != nil {
+			return err
+		}
+	}
+	return deleteManifestRevision(img, digest)
+}
+
+// Deletes a manifest unless it is still tagged, referenced by a manifest list or image index or refers to an existing subject.
+// Deleting a manifest list or image index also deletes its no longer referenced manifests.
+// Deleting a subject also deletes its no longer referenced referrers.
+func deleteManifestIfUnreferenced(img, digest string) error {
+	referenced, err := isManifestReferenced(img, digest)
+	if err != nil || referenced {
+		return err
+	}
+	return deleteManifestRevision(img, digest)
+}
+
+func deleteManifestRevision(img, digest string) error {
+	fn := getManifestRevisionFilename(img, digest)
+	manifestJson, err := readJson(fn)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	logging.Debug(LOG, "deleting unreferenced manifest %s@%s", img, digest)
+	err = deleteFile(fn)
+	if err != nil {
+		return err
+	}
+
+	if manifestJson != nil {
+		err = unindexReferrer(img, digest, manifestJson)
+		if err != nil {
+			return err
+		}
+	}
+
+	referrerDigests, err := getReferrerDigests(img, digest)
+	if err != nil {
+		return err
+	}
+	for _, referrerDigest := range referrerDigests {
+		err = deleteManifestIfUnreferenced(img, referrerDigest)
+		if err != nil {
+			return err
+		}
+	}
+
+	if manifestJson == nil || !isIndexManifest(manifestJson) {
+		return nil
+	}
+	childDigests, err := getIndexManifestDigests(manifestJson)
+	if err != nil {
+		return err
+	}
+	for _, childDigest := range childDigests {
+		err = deleteManifestIfUnreferenced(img, childDigest)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func Cleanup() {
@@ -264,112 +633,140 @@ func Cleanup() {
 	for _, img := range imgs {
 		CleanupImage(img)
 	}
+	err = cleanupBlobStore()
+	if err != nil {
+		logging.Error(LOG, "cleanup failed to clean up the blob store: %s", err.Error())
+	}
 }
 
+// Unlinks the blobs which no manifest of the image references, except for blobs linked within the grace period.
+// Deletes the image if nothing is left.
 func CleanupImage(img string) {
 	logging.Debug(LOG, "cleanup image %s", img)
 
-	tags, err := getImageTags(img)
+	unlock := lockImage(img)
+	defer unlock()
+
+	// tagged and untagged manifests, e.g. the manifests of a multi-arch image index
+	manifestFns, err := getManifestFiles(img)
 	if err != nil {
-		logging.Error(LOG, "cleanup failed to get image tags")
+		logging.Error(LOG, "cleanup failed to get image manifests")
 		return
 	}
 
 	var digests = map[string]bool{}
 
-	for _, tag := range tags {
-		logging.Debug(LOG, "cleanup image %s:%s", img, tag)
-		manifestJson, err := getManifestJson(img, tag)
+	for _, manifestFn := range manifestFns {
+		logging.Debug(LOG, "cleanup image %s manifest %s", img, manifestFn)
+		manifestJson, err := readJson(manifestFn)
 		if err != nil {
 			logging.Error(LOG, "cleanup failed to get image manifest json")
 			continue
 		}
 
-		configDigest, err := getManifestConfigDigest(manifestJson)
-		if err != nil {
-			logging.Error(LOG, "cleanup failed to get image config digest from manifest json")
-			continue
-		}
-
-		layerDigests, err := getManifestLayerDigests(manifestJson)
+		blobDigests, err := getManifestBlobDigests(manifestJson)
 		if err != nil {
-			logging.Error(LOG, "cleanup failed to get image layer digests from manifest json")
+			logging.Error(LOG, "cleanup failed to get image blob digests from manifest json")
 			continue
 		}
 
-		digests[configDigest] = true
-		for _, layerDigest := range layerDigests {
-			digests[layerDigest] = true
+		for _, blobDigest := range blobDigests {
+			digests[blobDigest] = true
 		}
 	}
 
-	blobs, err := getBlobFiles(img)
+	blobDigests, err := getImageBlobDigests(img)
 	if err != nil {
 		logging.Error(LOG, "cleanup failed to get image blobs %s", img)
 		return
 	}
 
-	for _, blob := range blobs {
-		fileDigest := fn2digest(filepath.Base(blob))
-		if _, ok := digests[fileDigest]; !ok {
-			logging.Debug(LOG, "deleting orphaned blob %s", blob)
-			err = filesys.DeleteFile(blob)
-			if err != nil {
-				logging.Warn(LOG, "failed to delete orphaned blob %s", blob)
+	gracePeriod := config.BlobGracePeriod()
+	var retry time.Duration = 0
+	nKept := 0
+	for _, blobDigest := range blobDigests {
+		if _, ok := digests[blobDigest]; ok {
+			continue
+		}
+		linkFn := getBlobLinkFilename(img, blobDigest)
+		fileInfo, err := store().Stat(linkFn)
+		if err == nil && time.Since(fileInfo.Modified) < gracePeriod {
+			// e.g. a layer of a push whose manifest has not arrived yet
+			nKept++
+			remaining := gracePeriod - time.Since(fileInfo.Modified)
+			if retry == 0 || remaining < retry {
+				retry = remaining
 			}
+			continue
+		}
+		logging.Debug(LOG, "unlinking orphaned blob %s from %s", blobDigest, img)
+		err = unlinkBlob(img, blobDigest)
+		if err != nil {
+			logging.Warn(LOG, "failed to unlink orphaned blob %s from %s", blobDigest, img)
 		}
 	}
+	if retry > 0 {
+		requestCleanupAfter(img, retry)
+	}
 
-	// check if image has remaining tags, otherwise delete image directory
-	tags, err = getImageTags(img)
+	// check if image has remaining manifests or tags, otherwise delete image directory
+	manifestFns, err = getManifestFiles(img)
 	if err != nil {
+		logging.Error(LOG, "cleanup failed to get image manifests")
+		return
+	}
+	tags, err := getImageTags(img)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		logging.Error(LOG, "cleanup failed to get image tags")
 		return
 	}
-	if len(tags) == 0 {
-		dir, err := getImageServedDir(img)
-		if err != nil {
-			logging.Error(LOG, "cleanup failed to get image directory")
-			return
-		}
+	uploadsDir := getBlobUploadsDir(img)
+	if len(manifestFns) == 0 && len(tags) == 0 && nKept == 0 && !exists(uploadsDir) {
+		dir := getImageServedDir(img)
 		logging.Debug(LOG, "deleting image directory %s", dir)
-		err = filesys.DeleteDir(dir)
+		err = deleteImageDir(dir)
 		if err != nil {
 			logging.Error(LOG, "cleanup failed to delete image directory %s", dir)
 		}
 	}
 }
 
-func getImageServedDir(img string) (string, error) {
-	dir := filepath.Join(config.RepoDir(), config.ServerPath(), img)
-	dir, err := filepath.Abs(dir)
-	if err != nil {
-		return "", err
+// Deletes the image's data, but keeps nested images, e.g. deleting team/project keeps team/project/image
+func deleteImageDir(dir string) error {
+	for _, name := range reservedNames {
+		err := deleteFile(storage.Join(dir, name))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
 	}
-	return dir, nil
+	return nil
 }
 
-// repo/v2/imagename/uploads/uploadUid
-func getBlobUploadFilename(img, uploadUuid string) (string, error) {
-	fn := filepath.Join(config.RepoDir(), config.ServerPath(), img, "uploads", uploadUuid)
-	fn, err := filepath.Abs(fn)
-	if err != nil {
-		return "", err
+// Note that the directory of a namespace, e.g. team of team/image, is not an image
+func imageExists(img string) bool {
+	dir := getImageServedDir(img)
+	for _, name := range reservedNames {
+		if isDir(storage.Join(dir, name)) {
+			return true
+		}
 	}
-	return fn, nil
+	return false
 }
 
-// repo/v2/imagename/blobs/digest
-func getBlobServedFilename(img, digest string) (string, error) {
-	fn := filepath.Join(config.RepoDir(), config.ServerPath(), img, "blobs", digest2fn(digest))
-	fn, err := filepath.Abs(fn)
-	if err != nil {
-		return "", err
+// v2/imagename
+func getImageServedDir(img string) string {
+	return servedPath(img)
+}
+
+// v2/imagename/uploads/uploadUid
+func getBlobUploadFilename(img, uploadUuid string) (string, error) {
+	if _, err := uuid.Parse(uploadUuid); err != nil {
+		return "", fmt.Errorf("%w: %s", ErrBlobUploadUnknown, uploadUuid)
 	}
-	return fn, nil
+	return servedPath(img, "uploads", uploadUuid), nil
 }
 
-// repo/v2/imagename/blobs/digest
+// v2/imagename/blobs/digest
 func getBlobServedUrlPath(img, digest string) string {
 	return config.ServerPath() + "/" + img + "/blobs/" + digest
 }
@@ -379,37 +776,14 @@ func getBlobServedUri(img, digest string, r *http.Request) string {
 	return config.ServerUrl(r) + getBlobServedUrlPath(img, digest)
 }
 
-// repo/v2/imagename/manifests/tag
-func getManifestServedDir(img, tag string) (string, error) {
-	dir := filepath.Join(config.RepoDir(), config.ServerPath(), img, "manifests", tag)
-	dir, err := filepath.Abs(dir)
-	if err != nil {
-		return "", err
-	}
-	return dir, nil
+// v2/imagename/manifests/tag
+func getTagFilename(img, tag string) string {
+	return servedPath(img, "manifests", tag)
 }
 
-// repo/v2/imagename/manifests/tag/digest
-func getManifestServedFilename(img, tag, digest string) (string, error) {
-	dir, err := getManifestServedDir(img, tag)
-	if err != nil {
-		return "", err
-	}
-	fn := filepath.Join(dir, digest2fn(digest))
-	fn, err = filepath.Abs(fn)
-	if err != nil {
-		return "", err
-	}
-	return fn, nil
-}
-
-func findManifestServedFilename(img, digest string) (string, error) {
-	dir := filepath.Join(config.RepoDir(), config.ServerPath(), img, "manifests")
-	dir, err := filepath.Abs(dir)
-	if err != nil {
-		return "", err
-	}
-	return filesys.FindFile(dir, digest2fn(digest))
+// v2/imagename/revisions/digest
+func getManifestRevisionFilename(img, digest string) string {
+	return servedPath(img, "revisions", digest2fn(digest))
 }
 
 func digest2fn(digest string) string {
@@ -420,61 +794,112 @@ func fn2digest(fn string) string {
 	return strings.Replace(fn, "-", ":", 1)
 }
 
-func getImages() ([]string, error) {
-	dir := filepath.Join(config.RepoDir(), config.ServerPath())
-	dir, err := filepath.Abs(dir)
+// Returns the names of all images in lexical order
+func GetImages() ([]string, error) {
+	imgs, err := getImages()
 	if err != nil {
 		return nil, err
 	}
-	return filesys.GetAllFilenamesInDir(dir)
+	sort.Strings(imgs)
+	return imgs, nil
 }
 
-func getImageTags(img string) ([]string, error) {
-	dir := filepath.Join(config.RepoDir(), config.ServerPath(), img, "manifests")
-	dir, err := filepath.Abs(dir)
-	if err != nil {
-		return nil, err
+func getImages() ([]string, error) {
+	dir := servedPath()
+	if !exists(dir) {
+		return []string{}, nil
 	}
-	return filesys.GetAllFilenamesInDir(dir)
+	return findImages(dir, "")
 }
 
-func getManifestFile(img, tag string) (string, error) {
-	dir := filepath.Join(config.RepoDir(), config.ServerPath(), img, "manifests", tag)
-	dir, err := filepath.Abs(dir)
-	if err != nil {
-		return "", err
+// Image directories may be nested, e.g. v2/team/project and v2/team/project/image
+func findImages(dir, prefix string) ([]string, error) {
+	fns, err := listDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []string{}, nil
 	}
-	fn, err := filesys.GetFirstFilenameInDir(dir)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return filepath.Join(dir, fn), nil
+	imgs := []string{}
+	isImage := false
+	for _, fn := range fns {
+		if isReservedName(fn) {
+			isImage = true
+			continue
+		}
+		sub := storage.Join(dir, fn)
+		if !isDir(sub) {
+			continue
+		}
+		subImgs, err := findImages(sub, prefix+fn+"/")
+		if err != nil {
+			return nil, err
+		}
+		imgs = append(imgs, subImgs...)
+	}
+	if isImage && prefix != "" {
+		imgs = append(imgs, strings.TrimSuffix(prefix, "/"))
+	}
+	return imgs, nil
 }
 
-func getManifestJson(img, tag string) (*json.JsonObject, error) {
-	manifest, err := getManifestFile(img, tag)
+// Returns the tags of an image in lexical order, ErrNameUnknown if the image does not exist
+func GetImageTags(img string) ([]string, error) {
+	if !imageExists(img) {
+		return nil, fmt.Errorf("%w: %s", ErrNameUnknown, img)
+	}
+	tags, err := getImageTags(img)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []string{}, nil
+	}
 	if err != nil {
 		return nil, err
 	}
-	return json.DecodeFile(manifest)
+	sort.Strings(tags)
+	return tags, nil
 }
 
-func getBlobFiles(img string) ([]string, error) {
-	dir := filepath.Join(config.RepoDir(), config.ServerPath(), img, "blobs")
-	dir, err := filepath.Abs(dir)
+func getImageTags(img string) ([]string, error) {
+	dir := servedPath(img, "manifests")
+	fns, err := listDir(dir)
 	if err != nil {
 		return nil, err
 	}
-	fns, err := filesys.GetAllFilenamesInDir(dir)
+	tags := []string{}
+	for _, fn := range fns {
+		if isValidTag(fn) && !isDir(storage.Join(dir, fn)) {
+			tags = append(tags, fn)
+		}
+	}
+	return tags, nil
+}
+
+// Returns the files of all tagged and untagged manifests
+func getManifestFiles(img string) ([]string, error) {
+	dir := servedPath(img, "revisions")
+	fns, err := listDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []string{}, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 	for i, fn := range fns {
-		fns[i] = filepath.Join(dir, fn)
+		fns[i] = storage.Join(dir, fn)
 	}
 	return fns, nil
 }
 
+func getManifestJson(img, reference string) (*json.JsonObject, error) {
+	digest, err := resolveManifest(img, reference)
+	if err != nil {
+		return nil, err
+	}
+	fn := getManifestRevisionFilename(img, digest)
+	return readJson(fn)
+}
+
 func getManifestConfig(manifestJson *json.JsonObject) (*json.JsonObject, error) {
 	if config := manifestJson.GetObject("config", nil); config != nil {
 		return config, nil
@@ -0,0 +1,58 @@
+package repo
+
+import (
+	"bytes"
+	"io"
+	"mosi-docker-registry/pkg/config"
+	"mosi-docker-registry/pkg/filesys"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Reads the config configJson from a new temporary directory, which holds the repository in its repo directory.
+// An empty configJson uses the default config. Returns the directory.
+func useTestConfig(t *testing.T, configJson string) string {
+	dir := t.TempDir()
+	writeTestConfig(t, dir, configJson)
+	return dir
+}
+
+// Replaces the config of dir, e.g. to change a setting of the same repository
+func writeTestConfig(t *testing.T, dir, configJson string) {
+	fn := filepath.Join(dir, "config.json")
+	if configJson != "" {
+		assert.Nil(t, os.WriteFile(fn, []byte(configJson), 0600))
+	}
+	config.ReadIfExists(dir, fn)
+}
+
+// Pushes the content into the image like a monolithic upload and returns its digest
+func pushTestBlob(t *testing.T, img string, content []byte) string {
+	digest, err := uploadTestBlob(img, content, bytes.NewReader(content))
+	assert.Nil(t, err)
+	return digest
+}
+
+// Uploads what the reader returns for the content and stores it as blob of the image
+func uploadTestBlob(img string, content []byte, reader io.Reader) (string, error) {
+	digest, err := filesys.CreateDigestFromBuffer(content)
+	if err != nil {
+		return "", err
+	}
+	uploadUuid, err := CreateBlobUpload(img)
+	if err != nil {
+		return "", err
+	}
+	_, err = UploadBlobChunk(img, uploadUuid, -1, -1, reader)
+	if err != nil {
+		return "", err
+	}
+	uploadFn, err := getBlobUploadFilename(img, uploadUuid)
+	if err != nil {
+		return "", err
+	}
+	return digest, storeBlob(img, digest, uploadFn)
+}
@@ -0,0 +1,116 @@
+package repo
+
+import (
+	"mosi-docker-registry/pkg/config"
+	"mosi-docker-registry/pkg/json"
+	"mosi-docker-registry/pkg/logging"
+	"mosi-docker-registry/pkg/wildcard"
+	"sort"
+	"time"
+)
+
+// Retention rules of the config delete old tags, e.g. the tags of pull request builds.
+// The age of a tag is the time it was last pushed.
+
+// Applies the retention rules periodically
+func StartRetention() {
+	interval := config.RetentionInterval()
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		for {
+			time.Sleep(interval)
+			if len(config.RetentionRules()) == 0 {
+				continue
+			}
+			_, err := ApplyRetention(false)
+			if err != nil {
+				logging.Error(LOG, "retention failed: %s", err.Error())
+			}
+		}
+	}()
+}
+
+// Deletes the tags the retention rules select, dry only reports them
+func ApplyRetention(dry bool) (*json.JsonObject, error) {
+	rules := config.RetentionRules()
+	now := time.Now()
+	nTags := 0
+	res, err := deleteImages("*", func(img string, tags []string) ([]string, error) {
+		expired, err := selectExpiredTags(img, tags, rules, now)
+		nTags += len(expired)
+		return expired, err
+	}, dry)
+	if err != nil {
+		return nil, err
+	}
+	if nTags > 0 {
+		logging.Info(LOG, "retention selected %d tags, dry %v", nTags, dry)
+	}
+	return res, nil
+}
+
+type taggedTime struct {
+	tag      string
+	modified time.Time
+}
+
+// Returns the tags of the image which a rule selects and no rule protects
+func selectExpiredTags(img string, tags []string, rules []config.RetentionRule, now time.Time) ([]string, error) {
+	imgRules := []config.RetentionRule{}
+	for _, rule := range rules {
+		if wildcard.Matches(img, rule.Image) {
+			imgRules = append(imgRules, rule)
+		}
+	}
+	if len(imgRules) == 0 {
+		return []string{}, nil
+	}
+
+	// newest first
+	tagged := []taggedTime{}
+	for _, tag := range tags {
+		fn := getTagFilename(img, tag)
+		fileInfo, err := store().Stat(fn)
+		if err != nil {
+			// deleted meanwhile
+			continue
+		}
+		tagged = append(tagged, taggedTime{tag: tag, modified: fileInfo.Modified})
+	}
+	sort.SliceStable(tagged, func(i, j int) bool {
+		return tagged[i].modified.After(tagged[j].modified)
+	})
+
+	expired := map[string]bool{}
+	protected := map[string]bool{}
+	for _, rule := range imgRules {
+		n := 0
+		for _, t := range tagged {
+			if !wildcard.Matches(t.tag, rule.Tag) {
+				continue
+			}
+			if rule.Protect != "" && wildcard.Matches(t.tag, rule.Protect) {
+				protected[t.tag] = true
+				continue
+			}
+			n++
+			if rule.KeepLast > 0 && n > rule.KeepLast {
+				expired[t.tag] = true
+			}
+			if rule.MaxAgeDays > 0 && now.Sub(t.modified) > time.Duration(rule.MaxAgeDays)*24*time.Hour {
+				expired[t.tag] = true
+			}
+		}
+	}
+
+	selected := []string{}
+	for _, t := range tagged {
+		if expired[t.tag] && !protected[t.tag] {
+			selected = append(selected, t.tag)
+		}
+	}
+	sort.Strings(selected)
+	return selected, nil
+}
@@ -0,0 +1,67 @@
+package repo
+
+import (
+	"mosi-docker-registry/pkg/config"
+	"mosi-docker-registry/pkg/filesys"
+	"mosi-docker-registry/pkg/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetention(t *testing.T) {
+	assert := assert.New(t)
+
+	useTestConfig(t, `{"repo":{"dir":"repo","retention":[
+		{"image": "app", "tag": "pr-*", "keepLast": 2, "protect": "pr-main"},
+		{"image": "app", "tag": "*", "maxAgeDays": 30, "protect": "latest"}
+	]}}`)
+
+	content := []byte(`{"schemaVersion":2,"layers":[]}`)
+	digest, _ := filesys.CreateDigestFromBuffer(content)
+	revisionFn := getManifestRevisionFilename("app", digest)
+	assert.Nil(writeBytes(revisionFn, content))
+
+	// tags pushed the given number of days ago
+	pushed := func(tag string, daysAgo int) {
+		assert.Nil(writeTag("app", tag, digest))
+		tagFn := getTagFilename("app", tag)
+		modified := time.Now().Add(-time.Duration(daysAgo) * 24 * time.Hour)
+		assert.Nil(os.Chtimes(filepath.Join(config.RepoDir(), filepath.FromSlash(tagFn)), modified, modified))
+	}
+	pushed("pr-main", 10)
+	pushed("pr-1", 5)
+	pushed("pr-2", 4)
+	pushed("pr-3", 3)
+	pushed("pr-4", 2)
+	pushed("1.0", 40)
+	pushed("latest", 50)
+
+	rows := func(res *json.JsonObject) [][]string {
+		tableRows := res.GetArray("tables", json.NewJsonArray(0)).GetObject(0, json.NewJsonObject()).GetArray("rows", json.NewJsonArray(0))
+		result := [][]string{}
+		for i := 0; i < tableRows.Len(); i++ {
+			result = append(result, tableRows.GetArray(i, nil).ToStringArray(""))
+		}
+		return result
+	}
+
+	res, err := ApplyRetention(true)
+	assert.Nil(err)
+	assert.Equal([][]string{{"app", "1.0", "NO"}, {"app", "pr-1", "NO"}, {"app", "pr-2", "NO"}}, rows(res))
+	tags, _ := getImageTags("app")
+	assert.Len(tags, 7)
+
+	res, err = ApplyRetention(false)
+	assert.Nil(err)
+	assert.Len(rows(res), 3)
+	tags, _ = getImageTags("app")
+	assert.ElementsMatch([]string{"latest", "pr-3", "pr-4", "pr-main"}, tags)
+
+	res, err = ApplyRetention(true)
+	assert.Nil(err)
+	assert.Empty(rows(res))
+}
@@ -0,0 +1,137 @@
+package repo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mosi-docker-registry/pkg/config"
+	"mosi-docker-registry/pkg/json"
+	"mosi-docker-registry/pkg/logging"
+	"mosi-docker-registry/pkg/storage"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// The repository is stored by the storage driver of the config's repo section.
+// Paths are relative to the driver's root, e.g. v2/imagename/manifests/tag.
+
+var storageDriver storage.Driver
+var storageDriverKey string
+var storageDriverMutex sync.Mutex
+
+var gmtTimeLoc = time.FixedZone("GMT", 0)
+
+// Returns the storage driver, a new one if the config changed
+func store() storage.Driver {
+	storageDriverMutex.Lock()
+	defer storageDriverMutex.Unlock()
+
+	key := fmt.Sprintf("%s:%s:%v", config.RepoDriver(), config.RepoDir(), s3Config())
+	if storageDriver == nil || key != storageDriverKey {
+		driver, err := storage.New(config.RepoDriver(), config.RepoDir(), s3Config())
+		if err != nil {
+			logging.Fatal(LOG, "%s", err.Error())
+		}
+		storageDriver = driver
+		storageDriverKey = key
+		forgetImageUsages()
+	}
+	return storageDriver
+}
+
+func s3Config() storage.S3Config {
+	return storage.S3Config{
+		Endpoint:  config.RepoS3Endpoint(),
+		Region:    config.RepoS3Region(),
+		Bucket:    config.RepoS3Bucket(),
+		Prefix:    config.RepoS3Prefix(),
+		AccessKey: config.RepoS3AccessKey(),
+		SecretKey: config.RepoS3SecretKey(),
+	}
+}
+
+// Returns an error if the configured storage driver does not exist or cannot access its storage, e.g. an unknown bucket
+func CheckStorage() error {
+	driver, err := storage.New(config.RepoDriver(), config.RepoDir(), s3Config())
+	if err != nil {
+		return err
+	}
+	_, err = driver.List("")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// v2/elem/...
+func servedPath(elem ...string) string {
+	return storage.Join(append([]string{config.ServerPath()}, elem...)...)
+}
+
+func exists(path string) bool {
+	return storage.Exists(store(), path)
+}
+
+func isDir(path string) bool {
+	return storage.IsDir(store(), path)
+}
+
+func size(path string) (int64, error) {
+	fileInfo, err := store().Stat(path)
+	if err != nil {
+		return -1, err
+	}
+	return fileInfo.Size, nil
+}
+
+// "Tue, 29 Nov 2022 14:56:29 GMT"
+func modifiedHttpDate(path string) (string, error) {
+	fileInfo, err := store().Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return fileInfo.Modified.In(gmtTimeLoc).Format(http.TimeFormat), nil
+}
+
+func readBytes(path string) ([]byte, error) {
+	return storage.ReadBytes(store(), path)
+}
+
+func writeBytes(path string, data []byte) error {
+	return storage.WriteBytes(store(), path, data)
+}
+
+func readJson(path string) (*json.JsonObject, error) {
+	content, err := readBytes(path)
+	if err != nil {
+		return nil, err
+	}
+	return json.DecodeBytes(content)
+}
+
+func deleteFile(path string) error {
+	return store().Delete(path)
+}
+
+func listDir(dir string) ([]string, error) {
+	return store().List(dir)
+}
+
+func createDigest(path string) (string, error) {
+	r, err := store().Reader(path)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	sha := sha256.New()
+	if _, err := io.Copy(sha, r); err != nil {
+		return "", err
+	}
+
+	return "sha256:" + hex.EncodeToString(sha.Sum(nil)), nil
+}
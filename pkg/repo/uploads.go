@@ -0,0 +1,172 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"mosi-docker-registry/pkg/config"
+	"mosi-docker-registry/pkg/filesys"
+	"mosi-docker-registry/pkg/json"
+	"mosi-docker-registry/pkg/logging"
+	"mosi-docker-registry/pkg/storage"
+	"mosi-docker-registry/pkg/wildcard"
+	"sync"
+	"time"
+)
+
+// Upload sessions live in v2/imagename/uploads/uploadUuid until the blob is complete.
+// Sessions of interrupted pushes which do not receive any data for longer than config.UploadMaxIdle() are stale and get reaped.
+
+const maxReapedUploads = 100
+
+type upload struct {
+	img      string
+	uuid     string
+	size     int64
+	modified time.Time
+	reaped   time.Time
+}
+
+// The most recently reaped uploads for the uploads command
+var reapedUploads []upload
+var reapedUploadsMutex sync.Mutex
+
+// Deletes an upload session
+func CancelBlobUpload(img, uploadUuid string) error {
+	fn, err := getBlobUploadFilename(img, uploadUuid)
+	if err != nil {
+		return err
+	}
+	err = deleteFile(fn)
+	forgetImageUsage(img)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrBlobUploadUnknown, uploadUuid)
+	}
+	return err
+}
+
+// Reaps stale uploads periodically
+func StartUploadReaper() {
+	maxIdle := config.UploadMaxIdle()
+	if maxIdle <= 0 {
+		return
+	}
+	interval := maxIdle / 10
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+	go func() {
+		for {
+			ReapUploads(maxIdle)
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// Deletes the upload sessions which did not receive any data for longer than maxIdle
+func ReapUploads(maxIdle time.Duration) {
+	uploads, err := getUploads()
+	if err != nil {
+		logging.Error(LOG, "reaper failed to get uploads: %s", err.Error())
+		return
+	}
+	now := time.Now()
+	for _, u := range uploads {
+		idle := now.Sub(u.modified)
+		if idle <= maxIdle {
+			continue
+		}
+		err = CancelBlobUpload(u.img, u.uuid)
+		if err != nil {
+			logging.Error(LOG, "reaper failed to delete upload %s of %s: %s", u.uuid, u.img, err.Error())
+			continue
+		}
+		logging.Info(LOG, "reaped upload %s of %s, %s idle for %s", u.uuid, u.img, filesys.Bytes2IEC(u.size), idle.Round(time.Second))
+		u.reaped = now
+		addReapedUpload(u)
+	}
+}
+
+func addReapedUpload(u upload) {
+	reapedUploadsMutex.Lock()
+	defer reapedUploadsMutex.Unlock()
+	reapedUploads = append(reapedUploads, u)
+	if len(reapedUploads) > maxReapedUploads {
+		reapedUploads = reapedUploads[1:]
+	}
+}
+
+// Lists the active, stale and recently reaped upload sessions
+func ListUploads(imgPattern string) (*json.JsonObject, error) {
+	uploads, err := getUploads()
+	if err != nil {
+		return nil, err
+	}
+
+	tables := json.NewJsonArray(0)
+	res := json.NewJsonObject()
+	res.Put("tables", tables)
+
+	table := json.NewJsonObject()
+	table.Put("fields", json.JsonArrayFromStrings("Image", "Upload", "Size", "Idle", "Status"))
+	tables.Add(table)
+	rows := json.NewJsonArray(0)
+	table.Put("rows", rows)
+
+	now := time.Now()
+	maxIdle := config.UploadMaxIdle()
+
+	for _, u := range uploads {
+		if wildcard.Matches(u.img, imgPattern) {
+			idle := now.Sub(u.modified)
+			status := "active"
+			if maxIdle > 0 && idle > maxIdle {
+				status = "stale"
+			}
+			rows.Add(json.JsonArrayFromStrings(u.img, u.uuid, filesys.Bytes2IEC(u.size), idle.Round(time.Second).String(), status))
+		}
+	}
+
+	reapedUploadsMutex.Lock()
+	defer reapedUploadsMutex.Unlock()
+	for _, u := range reapedUploads {
+		if wildcard.Matches(u.img, imgPattern) {
+			idle := u.reaped.Sub(u.modified)
+			status := "reaped " + u.reaped.Format(time.RFC3339)
+			rows.Add(json.JsonArrayFromStrings(u.img, u.uuid, filesys.Bytes2IEC(u.size), idle.Round(time.Second).String(), status))
+		}
+	}
+
+	return res, nil
+}
+
+func getUploads() ([]upload, error) {
+	imgs, err := getImages()
+	if err != nil {
+		return nil, err
+	}
+	uploads := []upload{}
+	for _, img := range imgs {
+		dir := getBlobUploadsDir(img)
+		fns, err := listDir(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, fn := range fns {
+			fileInfo, err := store().Stat(storage.Join(dir, fn))
+			if err != nil {
+				continue
+			}
+			uploads = append(uploads, upload{img: img, uuid: fn, size: fileInfo.Size, modified: fileInfo.Modified})
+		}
+	}
+	return uploads, nil
+}
+
+// v2/imagename/uploads
+func getBlobUploadsDir(img string) string {
+	return servedPath(img, "uploads")
+}
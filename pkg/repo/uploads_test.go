@@ -0,0 +1,45 @@
+package repo
+
+import (
+	"mosi-docker-registry/pkg/config"
+	"mosi-docker-registry/pkg/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReapUploads(t *testing.T) {
+	assert := assert.New(t)
+
+	useTestConfig(t, "")
+
+	active, err := CreateBlobUpload("team/app")
+	assert.Nil(err)
+	stale, err := CreateBlobUpload("team/app")
+	assert.Nil(err)
+
+	fn, err := getBlobUploadFilename("team/app", stale)
+	assert.Nil(err)
+	past := time.Now().Add(-2 * time.Hour)
+	assert.Nil(os.Chtimes(filepath.Join(config.RepoDir(), filepath.FromSlash(fn)), past, past))
+
+	ReapUploads(time.Hour)
+
+	_, err = GetBlobUploadSize("team/app", active)
+	assert.Nil(err)
+	_, err = GetBlobUploadSize("team/app", stale)
+	assert.ErrorIs(err, ErrBlobUploadUnknown)
+
+	list, err := ListUploads("team/*")
+	assert.Nil(err)
+	rows := list.GetArray("tables", json.NewJsonArray(0)).GetObject(0, nil).GetArray("rows", nil)
+	assert.Equal(2, rows.Len())
+	assert.Equal("active", rows.GetArray(0, nil).GetString(4, ""))
+	assert.Contains(rows.GetArray(1, nil).GetString(4, ""), "reaped")
+
+	assert.Nil(CancelBlobUpload("team/app", active))
+	assert.ErrorIs(CancelBlobUpload("team/app", active), ErrBlobUploadUnknown)
+}
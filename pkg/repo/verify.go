@@ -0,0 +1,294 @@
+package repo
+
+import (
+	"errors"
+	"io/fs"
+	"mosi-docker-registry/pkg/config"
+	"mosi-docker-registry/pkg/filesys"
+	"mosi-docker-registry/pkg/json"
+	"mosi-docker-registry/pkg/logging"
+	"mosi-docker-registry/pkg/wildcard"
+	"path"
+	"strings"
+	"time"
+)
+
+// The verification recomputes the digests of manifests and blobs and checks that everything a tag or manifest
+// references exists. It reports missing objects, corrupt objects whose content does not match their digest
+// and orphaned objects nothing references. The repair moves corrupt blobs into the quarantine,
+// so that pulls fail with BLOB_UNKNOWN and the next push of the blob stores it again.
+
+const (
+	problemMissing  = "MISSING"
+	problemCorrupt  = "CORRUPT"
+	problemOrphaned = "ORPHANED"
+)
+
+type verification struct {
+	repair bool
+	rows   *json.JsonArray
+	// blobs of the store checked so far and whether their content matches
+	blobs     map[string]bool
+	manifests int
+	problems  map[string]int
+}
+
+func (v *verification) report(img, object, digest, problem string, repaired bool) {
+	s := "NO"
+	if repaired {
+		s = "YES"
+	}
+	v.rows.Add(json.JsonArrayFromStrings(img, object, digest, problem, s))
+	v.problems[problem]++
+}
+
+// Verifies the images matching imgPattern, only the manifests of the tags matching tagPattern if it is not empty
+func Verify(imgPattern, tagPattern string, repair bool) (*json.JsonObject, error) {
+	imgs, err := getImages()
+	if err != nil {
+		return nil, err
+	}
+
+	v := &verification{repair: repair, rows: json.NewJsonArray(0), blobs: map[string]bool{}, problems: map[string]int{}}
+	nImgs := 0
+	for _, img := range imgs {
+		if !wildcard.Matches(img, imgPattern) {
+			continue
+		}
+		nImgs++
+		err = v.verifyImage(img, tagPattern)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// blobs of the store which no image links, only when verifying the whole repository
+	if imgPattern == "*" && tagPattern == "" {
+		linked, err := getLinkedBlobDigests()
+		if err != nil {
+			return nil, err
+		}
+		storedDigests, err := getStoredBlobDigests()
+		if err != nil {
+			return nil, err
+		}
+		for _, digest := range storedDigests {
+			if !linked[digest] {
+				v.report("(blob store)", "blob", digest, problemOrphaned, false)
+			}
+		}
+	}
+
+	tables := json.NewJsonArray(0)
+	res := json.NewJsonObject()
+	res.Put("tables", tables)
+
+	if v.rows.Len() > 0 {
+		table := json.NewJsonObject()
+		table.Put("fields", json.JsonArrayFromStrings("Image", "Object", "Digest", "Problem", "Repaired"))
+		table.Put("rows", v.rows)
+		tables.Add(table)
+	}
+	total := json.NewJsonObject()
+	total.Put("fields", json.JsonArrayFromStrings("Images", "Manifests", "Blobs", "Missing", "Corrupt", "Orphaned"))
+	total.Put("rows", json.NewJsonArray(0).Add(json.JsonArrayFromAny(nImgs, v.manifests, len(v.blobs), v.problems[problemMissing], v.problems[problemCorrupt], v.problems[problemOrphaned])))
+	tables.Add(total)
+
+	logging.Info(LOG, "verification found %d missing, %d corrupt and %d orphaned objects in %d images, repair %v",
+		v.problems[problemMissing], v.problems[problemCorrupt], v.problems[problemOrphaned], nImgs, repair)
+	return res, nil
+}
+
+func (v *verification) verifyImage(img, tagPattern string) error {
+	pending := []string{}
+	if tagPattern == "" {
+		manifestFns, err := getManifestFiles(img)
+		if err != nil {
+			return err
+		}
+		for _, fn := range manifestFns {
+			pending = append(pending, fn2digest(path.Base(fn)))
+		}
+	}
+
+	tags, err := getImageTags(img)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for _, tag := range tags {
+		if tagPattern != "" && !wildcard.Matches(tag, tagPattern) {
+			continue
+		}
+		digest, err := getTagDigest(img, tag)
+		if err != nil {
+			return err
+		}
+		fn := getManifestRevisionFilename(img, digest)
+		if !exists(fn) {
+			v.report(img, "tag "+tag, digest, problemMissing, false)
+			continue
+		}
+		pending = append(pending, digest)
+	}
+
+	// the blobs the verified manifests reference
+	referenced := map[string]bool{}
+	verified := map[string]bool{}
+	for len(pending) > 0 {
+		digest := pending[0]
+		pending = pending[1:]
+		if verified[digest] {
+			continue
+		}
+		verified[digest] = true
+
+		manifestJson, err := v.verifyManifest(img, digest)
+		if err != nil {
+			return err
+		}
+		if manifestJson == nil {
+			continue
+		}
+
+		if isIndexManifest(manifestJson) {
+			childDigests, err := getIndexManifestDigests(manifestJson)
+			if err != nil {
+				continue
+			}
+			for _, childDigest := range childDigests {
+				fn := getManifestRevisionFilename(img, childDigest)
+				if !exists(fn) {
+					v.report(img, "manifest", childDigest, problemMissing, false)
+					verified[childDigest] = true
+					continue
+				}
+				pending = append(pending, childDigest)
+			}
+			continue
+		}
+
+		blobDigests, err := getManifestBlobDigests(manifestJson)
+		if err != nil {
+			continue
+		}
+		for _, blobDigest := range blobDigests {
+			if referenced[blobDigest] {
+				continue
+			}
+			referenced[blobDigest] = true
+			err = v.verifyBlob(img, blobDigest)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if tagPattern != "" {
+		return nil
+	}
+
+	// links which no manifest references, except the blobs of a push in progress
+	blobDigests, err := getImageBlobDigests(img)
+	if err != nil {
+		return err
+	}
+	gracePeriod := config.BlobGracePeriod()
+	for _, blobDigest := range blobDigests {
+		if referenced[blobDigest] {
+			continue
+		}
+		linkFn := getBlobLinkFilename(img, blobDigest)
+		fileInfo, err := store().Stat(linkFn)
+		if err != nil || time.Since(fileInfo.Modified) < gracePeriod {
+			continue
+		}
+		v.report(img, "blob link", blobDigest, problemOrphaned, false)
+	}
+	return nil
+}
+
+// Returns the manifest if its content matches its digest, nil if it is missing, corrupt or invalid
+func (v *verification) verifyManifest(img, digest string) (*json.JsonObject, error) {
+	fn := getManifestRevisionFilename(img, digest)
+	content, err := readBytes(fn)
+	if errors.Is(err, fs.ErrNotExist) {
+		v.report(img, "manifest", digest, problemMissing, false)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	v.manifests++
+
+	if isSha256(digest) {
+		contentDigest, err := filesys.CreateDigestFromBuffer(content)
+		if err != nil {
+			return nil, err
+		}
+		if contentDigest != digest {
+			v.report(img, "manifest", digest, problemCorrupt, false)
+			return nil, nil
+		}
+	}
+	manifestJson, err := json.DecodeBytes(content)
+	if err != nil {
+		v.report(img, "manifest", digest, problemCorrupt, false)
+		return nil, nil
+	}
+	return manifestJson, nil
+}
+
+// Checks that the image links the blob and that the stored content matches its digest
+func (v *verification) verifyBlob(img, digest string) error {
+	linkFn := getBlobLinkFilename(img, digest)
+	storeFn := getBlobStoreFilename(digest)
+	if !exists(linkFn) {
+		v.report(img, "blob", digest, problemMissing, false)
+		return nil
+	}
+
+	// a blob shared by several images is checked and quarantined once
+	ok, checked := v.blobs[digest]
+	if !checked {
+		if !exists(storeFn) {
+			v.report(img, "blob", digest, problemMissing, false)
+			return nil
+		}
+		ok = true
+		if isSha256(digest) {
+			contentDigest, err := createDigest(storeFn)
+			if err != nil {
+				return err
+			}
+			ok = contentDigest == digest
+		}
+		v.blobs[digest] = ok
+		if !ok && v.repair {
+			err := quarantineBlob(digest)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if !ok {
+		v.report(img, "blob", digest, problemCorrupt, v.repair)
+	}
+	return nil
+}
+
+// Moves a corrupt blob out of the store
+func quarantineBlob(digest string) error {
+	unlock := lockBlob(digest)
+	defer unlock()
+
+	storeFn := getBlobStoreFilename(digest)
+	quarantineFn := getQuarantineFilename(digest)
+	logging.Warn(LOG, "moving corrupt blob %s into the quarantine", digest)
+	forgetBlobSize(digest)
+	return store().Move(storeFn, quarantineFn)
+}
+
+// The digests of other algorithms are not recomputed
+func isSha256(digest string) bool {
+	return strings.HasPrefix(digest, "sha256:")
+}
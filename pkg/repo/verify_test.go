@@ -0,0 +1,78 @@
+package repo
+
+import (
+	"fmt"
+	"mosi-docker-registry/pkg/filesys"
+	"mosi-docker-registry/pkg/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	assert := assert.New(t)
+
+	useTestConfig(t, `{"repo":{"dir":"repo","blobGracePeriodMinutes":0}}`)
+
+	// stores content under the digest of digestContent
+	storeContent := func(img, digestContent, content string) string {
+		digest, _ := filesys.CreateDigestFromBuffer([]byte(digestContent))
+		storeFn := getBlobStoreFilename(digest)
+		assert.Nil(writeBytes(storeFn, []byte(content)))
+		if img != "" {
+			assert.Nil(linkBlob(img, digest))
+		}
+		return digest
+	}
+
+	configDigest := storeContent("app", "config", "config")
+	corruptDigest := storeContent("app", "layer", "flipped bits")
+	missingDigest, _ := filesys.CreateDigestFromBuffer([]byte("lost layer"))
+	assert.Nil(linkBlob("app", missingDigest))
+	content := []byte(fmt.Sprintf(`{"schemaVersion":2,"config":{"digest":"%s"},"layers":[{"digest":"%s"},{"digest":"%s"}]}`, configDigest, corruptDigest, missingDigest))
+	digest, _ := filesys.CreateDigestFromBuffer(content)
+	revisionFn := getManifestRevisionFilename("app", digest)
+	assert.Nil(writeBytes(revisionFn, content))
+	assert.Nil(writeTag("app", "1.0", digest))
+	lostDigest, _ := filesys.CreateDigestFromBuffer([]byte("lost manifest"))
+	assert.Nil(writeTag("app", "2.0", lostDigest))
+	orphanedDigest := storeContent("", "orphaned", "orphaned")
+
+	problems := func(res *json.JsonObject) []string {
+		rows := res.GetArray("tables", json.NewJsonArray(0)).GetObject(0, json.NewJsonObject()).GetArray("rows", json.NewJsonArray(0))
+		result := []string{}
+		for i := 0; i < rows.Len(); i++ {
+			row := rows.GetArray(i, nil).ToStringArray("")
+			result = append(result, row[1]+" "+row[2]+" "+row[3]+" "+row[4])
+		}
+		return result
+	}
+
+	res, err := Verify("*", "", false)
+	assert.Nil(err)
+	assert.ElementsMatch([]string{
+		"tag 2.0 " + lostDigest + " MISSING NO",
+		"blob " + corruptDigest + " CORRUPT NO",
+		"blob " + missingDigest + " MISSING NO",
+		"blob " + orphanedDigest + " ORPHANED NO",
+	}, problems(res))
+
+	// only the manifests of the tag, the blob store is not checked
+	res, err = Verify("app", "1.0", false)
+	assert.Nil(err)
+	assert.Len(problems(res), 2)
+
+	res, err = Verify("app", "1.0", true)
+	assert.Nil(err)
+	assert.Contains(problems(res), "blob "+corruptDigest+" CORRUPT YES")
+	storeFn := getBlobStoreFilename(corruptDigest)
+	assert.False(exists(storeFn))
+	quarantineFn := getQuarantineFilename(corruptDigest)
+	data, _ := readBytes(quarantineFn)
+	assert.Equal("flipped bits", string(data))
+
+	// the quarantined blob is missing until it is pushed again
+	res, err = Verify("app", "1.0", false)
+	assert.Nil(err)
+	assert.Contains(problems(res), "blob "+corruptDigest+" MISSING NO")
+}
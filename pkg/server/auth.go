@@ -18,35 +18,57 @@ const (
 )
 
 type token struct {
-	time                int64
-	admin               bool
-	imagesAllowedToPull []string
-	imagesAllowedToPush []string
+	usr                   string
+	time                  int64
+	admin                 bool
+	imagesAllowedToPull   []string
+	imagesAllowedToPush   []string
+	imagesAllowedToDelete []string
 }
 
 var tokens = map[string]*token{}
 
 func initAuth(w http.ResponseWriter, r *http.Request) bool {
-	return checkAuth(w, r, "", false, false, false)
+	return checkAuth(w, r, "", false, false, false, false)
 }
 
 func checkAdminAuth(w http.ResponseWriter, r *http.Request) bool {
-	return checkAuth(w, r, "", false, false, true)
+	return checkAuth(w, r, "", false, false, false, true)
 }
 
 func checkPullAuth(w http.ResponseWriter, r *http.Request, img string) bool {
-	return checkAuth(w, r, img, true, false, false)
+	return checkAuth(w, r, img, true, false, false, false)
 }
 
 func checkPushAuth(w http.ResponseWriter, r *http.Request, img string) bool {
-	return checkAuth(w, r, img, true, true, false)
+	return checkAuth(w, r, img, true, true, false, false)
 }
 
-func checkAuth(w http.ResponseWriter, r *http.Request, img string, allowAnonymous, wantPush, wantAdmin bool) bool {
-	if checkRequestAuth(r, img, allowAnonymous, wantPush, wantAdmin) {
+func checkDeleteAuth(w http.ResponseWriter, r *http.Request, img string) bool {
+	return checkAuth(w, r, img, true, false, true, false)
+}
+
+func checkAuth(w http.ResponseWriter, r *http.Request, img string, allowAnonymous, wantPush, wantDelete, wantAdmin bool) bool {
+	if checkRequestAuth(r, img, allowAnonymous, wantPush, wantDelete, wantAdmin) {
 		return true
 	}
 
+	// authenticated requests without the access rights are denied, others get challenged to authenticate
+	if r.Header.Get("Authorization") != "" && getRequestToken(r, allowAnonymous) != nil {
+		sendDenied(w)
+		return false
+	}
+
+	sendUnauthorized(w, r, allowAnonymous)
+	return false
+}
+
+func sendDenied(w http.ResponseWriter) {
+	setDefaultHeader(w)
+	sendError(w, 403, "DENIED", "requested access to the resource is denied")
+}
+
+func sendUnauthorized(w http.ResponseWriter, r *http.Request, allowAnonymous bool) {
 	setDefaultHeader(w)
 
 	tokenUrl := config.ServerUrl(r) + config.ServerTokenPath()
@@ -56,52 +78,74 @@ func checkAuth(w http.ResponseWriter, r *http.Request, img string, allowAnonymou
 	}
 
 	sendError(w, 401, "UNAUTHORIZED", "access to the requested resource is not authorized")
-	return false
 }
 
-func checkRequestAuth(r *http.Request, img string, allowAnonymous, wantPush, wantAdmin bool) bool {
+// Returns the token of the request's bearer or basic auth, nil if the request is not authorized at all
+func getRequestToken(r *http.Request, allowAnonymous bool) *token {
+	if token := getBearerToken(r); token != nil {
+		return token
+	}
+	_, token := createTokenFromBasicAuth(r, allowAnonymous, false)
+	return token
+}
+
+func checkRequestAuth(r *http.Request, img string, allowAnonymous, wantPush, wantDelete, wantAdmin bool) bool {
 
-	if checkTokenAuth(r, img, wantPush, wantAdmin) {
+	if checkTokenAuth(r, img, wantPush, wantDelete, wantAdmin) {
 		return true
 	}
 
-	if checkBasicAuth(r, img, allowAnonymous, wantPush, wantAdmin) {
+	if checkBasicAuth(r, img, allowAnonymous, wantPush, wantDelete, wantAdmin) {
 		return true
 	}
 
 	return false
 }
 
-func checkTokenAuth(r *http.Request, img string, wantPush, wantAdmin bool) bool {
+func checkTokenAuth(r *http.Request, img string, wantPush, wantDelete, wantAdmin bool) bool {
+	if token := getBearerToken(r); token != nil {
+		return checkTokenAccessRights(token, img, wantPush, wantDelete, wantAdmin)
+	}
+	return false
+}
+
+func getBearerToken(r *http.Request) *token {
 	auth := r.Header.Get("Authorization")
 	if !strings.HasPrefix(auth, "Bearer ") {
-		return false
+		return nil
 	}
 	tokenStr := auth[7:]
 	if token, ok := tokens[tokenStr]; ok {
 		now := time.Now().UnixMilli()
 		if tokenMaxAge >= 0 && now-token.time > tokenMaxAge {
 			delete(tokens, tokenStr)
-			return false
+			return nil
 		}
 		token.time = now
-		return checkTokenAccessRights(token, img, wantPush, wantAdmin)
+		return token
 	}
-	return false
+	return nil
 }
 
-func checkBasicAuth(r *http.Request, img string, allowAnonymous, wantPush, wantAdmin bool) bool {
+func checkBasicAuth(r *http.Request, img string, allowAnonymous, wantPush, wantDelete, wantAdmin bool) bool {
 	_, token := createTokenFromBasicAuth(r, allowAnonymous, false)
 	if token == nil {
 		return false
 	}
-	return checkTokenAccessRights(token, img, wantPush, wantAdmin)
+	return checkTokenAccessRights(token, img, wantPush, wantDelete, wantAdmin)
 }
 
-func checkTokenAccessRights(token *token, img string, wantPush, wantAdmin bool) bool {
+func checkTokenAccessRights(token *token, img string, wantPush, wantDelete, wantAdmin bool) bool {
 	if wantAdmin {
 		return token.admin
 	}
+	// the base endpoint /v2/ only checks that the request is authenticated, e.g. for docker login
+	if len(img) == 0 {
+		return true
+	}
+	if wantDelete {
+		return isImageAccessAllowed(token.imagesAllowedToDelete, img)
+	}
 	if wantPush {
 		return isImageAccessAllowed(token.imagesAllowedToPush, img)
 	} else {
@@ -134,34 +178,42 @@ func createTokenFromBasicAuth(r *http.Request, allowAnonymous, store bool) (stri
 
 	var imagesAllowedToPull []string = nil
 	var imagesAllowedToPush []string = nil
+	var imagesAllowedToDelete []string = nil
 
-	if scope := query.Get("scope"); scope != "" {
+	if images := getScopeImages(query["scope"]); len(images) > 0 {
 		// docker push/pull
 		// scope: "repository:imagename:pull,push"
+		// a push which mounts blobs from other images asks for several scopes
 
-		a := strings.Split(scope, ":")
-		image := a[1]
-		imagesAllowedToPull, imagesAllowedToPush = config.GetScopeImageAccessRights(image, usr, pwd, allowAnonymous)
+		for _, image := range images {
+			pull, push, del := config.GetScopeImageAccessRights(image, usr, pwd, allowAnonymous)
+			imagesAllowedToPull = append(imagesAllowedToPull, pull...)
+			imagesAllowedToPush = append(imagesAllowedToPush, push...)
+			imagesAllowedToDelete = append(imagesAllowedToDelete, del...)
+		}
 	} else {
-		// docker login
+		// docker login or catalog
+		// scope: "" or "registry:catalog:*"
 		// query.Get("account")
 		// query.Get("client_id")
 		// query.Get("offline_token")
 		// query.Get("service")
 
-		imagesAllowedToPull, imagesAllowedToPush = config.GetAccountImageAccessRights(usr, pwd, allowAnonymous)
+		imagesAllowedToPull, imagesAllowedToPush, imagesAllowedToDelete = config.GetAccountImageAccessRights(usr, pwd, allowAnonymous)
 	}
 
-	if !admin && imagesAllowedToPull == nil && imagesAllowedToPush == nil {
+	if !admin && imagesAllowedToPull == nil && imagesAllowedToPush == nil && imagesAllowedToDelete == nil {
 		return "", nil
 	}
 
 	tokenStr := "DockerToken." + uuid.New().String()
 	token := token{
-		time:                time.Now().UnixMilli(),
-		admin:               admin,
-		imagesAllowedToPull: imagesAllowedToPull,
-		imagesAllowedToPush: imagesAllowedToPush,
+		usr:                   usr,
+		time:                  time.Now().UnixMilli(),
+		admin:                 admin,
+		imagesAllowedToPull:   imagesAllowedToPull,
+		imagesAllowedToPush:   imagesAllowedToPush,
+		imagesAllowedToDelete: imagesAllowedToDelete,
 	}
 
 	if store {
@@ -172,6 +224,20 @@ func createTokenFromBasicAuth(r *http.Request, allowAnonymous, store bool) (stri
 	return tokenStr, &token
 }
 
+// Returns the image names of all repository scopes, each scope parameter may hold several space-separated scopes
+func getScopeImages(scopes []string) []string {
+	var images []string
+	for _, scope := range scopes {
+		for _, s := range strings.Fields(scope) {
+			if strings.HasPrefix(s, "repository:") {
+				a := strings.Split(s, ":")
+				images = append(images, a[1])
+			}
+		}
+	}
+	return images
+}
+
 func getUsrAndPwd(auth string) (string, string) {
 	if !strings.HasPrefix(auth, "Basic ") {
 		return "", ""
@@ -0,0 +1,17 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScopeImages(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(getScopeImages(nil))
+	assert.Nil(getScopeImages([]string{"registry:catalog:*"}))
+	assert.Equal([]string{"team/app"}, getScopeImages([]string{"repository:team/app:pull,push"}))
+	assert.Equal([]string{"app", "base"}, getScopeImages([]string{"repository:app:pull,push", "repository:base:pull"}))
+	assert.Equal([]string{"app", "base"}, getScopeImages([]string{"repository:app:pull,push repository:base:pull"}))
+}
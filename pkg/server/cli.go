@@ -51,6 +51,12 @@ func cliHandleGet(w http.ResponseWriter, r *http.Request) {
 	switch cmd {
 	case "ls":
 		cliHandleGetListImages(w, paths, args)
+	case "uploads":
+		cliHandleGetListUploads(w, paths, args)
+	case "verify":
+		cliHandleVerify(w, paths, false)
+	case "export":
+		cliHandleGetExport(w, args)
 	default:
 		sendError(w, 400, "BAD REQUEST", "Unknown command '"+cmd+"'")
 	}
@@ -62,8 +68,86 @@ func cliHandleGetListImages(w http.ResponseWriter, paths []string, args *json.Js
 	json, err := repo.List(img, tag)
 
 	if err != nil {
-		logging.Error(LOG, err)
-		w.WriteHeader(500)
+		sendRepoError(w, err, "list images")
+		return
+	}
+
+	sendJson(w, 200, json)
+}
+
+func cliHandleGetListUploads(w http.ResponseWriter, paths []string, args *json.JsonObject) {
+	img, _ := getImageAndTag(paths)
+
+	json, err := repo.ListUploads(img)
+
+	if err != nil {
+		sendRepoError(w, err, "list uploads")
+		return
+	}
+
+	sendJson(w, 200, json)
+}
+
+// Verifies the images, repair moves corrupt blobs into the quarantine and is only done by DELETE
+func cliHandleVerify(w http.ResponseWriter, paths []string, repair bool) {
+	img, tag := getImageAndTag(paths)
+
+	json, err := repo.Verify(img, tag, repair)
+
+	if err != nil {
+		sendRepoError(w, err, "verify images")
+		return
+	}
+
+	sendJson(w, 200, json)
+}
+
+// Sends the images of the args' refs as tarball
+func cliHandleGetExport(w http.ResponseWriter, args *json.JsonObject) {
+	refs := args.GetArray("refs", json.NewJsonArray(0)).ToStringArray("")
+	format := args.GetString("format", repo.ArchiveFormatOci)
+
+	archive, err := repo.PrepareExport(refs, format)
+
+	if err != nil {
+		sendRepoError(w, err, "export images")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.WriteHeader(200)
+
+	err = archive.Write(w)
+	if err != nil {
+		// the status is sent already, aborting the response lets the client see the broken transfer
+		logging.Error(LOG, "export images failed: %s", err.Error())
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// /v2/cli/...
+func cliHandlePost(w http.ResponseWriter, r *http.Request) {
+	ok, cmd, _, args := parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	switch cmd {
+	case "import":
+		cliHandlePostImport(w, r, args)
+	default:
+		sendError(w, 400, "BAD REQUEST", "Unknown command '"+cmd+"'")
+	}
+}
+
+// Imports the images of the tarball in the request body
+func cliHandlePostImport(w http.ResponseWriter, r *http.Request, args *json.JsonObject) {
+	name := args.GetString("name", "")
+
+	json, err := repo.Import(r.Body, name, getRequestUsr(r))
+
+	if err != nil {
+		sendRepoError(w, err, "import images")
 		return
 	}
 
@@ -80,6 +164,12 @@ func cliHandleDelete(w http.ResponseWriter, r *http.Request) {
 	switch cmd {
 	case "rm":
 		cliHandleDeleteImages(w, paths, args)
+	case "gc":
+		cliHandleGarbageCollect(w, args)
+	case "retention":
+		cliHandleRetention(w, args)
+	case "verify":
+		cliHandleVerify(w, paths, true)
 	default:
 		sendError(w, 400, "BAD REQUEST", "Unknown command '"+cmd+"'")
 	}
@@ -92,20 +182,44 @@ func cliHandleDeleteImages(w http.ResponseWriter, paths []string, args *json.Jso
 	json, err := repo.Delete(img, tag, dry)
 
 	if err != nil {
-		logging.Error(LOG, err)
-		w.WriteHeader(500)
+		sendRepoError(w, err, "delete images")
 		return
 	}
 
 	sendJson(w, 200, json)
 }
 
-func getImageAndTag(paths []string) (string, string) {
-	s := ""
-	if len(paths) > 0 {
-		s = paths[0]
+func cliHandleGarbageCollect(w http.ResponseWriter, args *json.JsonObject) {
+	dry := args.GetBool("dry", false)
+	untagged := args.GetBool("untagged", false)
+
+	json, err := repo.GarbageCollect(dry, untagged)
+
+	if err != nil {
+		sendRepoError(w, err, "collect garbage")
+		return
 	}
 
+	sendJson(w, 200, json)
+}
+
+func cliHandleRetention(w http.ResponseWriter, args *json.JsonObject) {
+	dry := args.GetBool("dry", false)
+
+	json, err := repo.ApplyRetention(dry)
+
+	if err != nil {
+		sendRepoError(w, err, "apply retention")
+		return
+	}
+
+	sendJson(w, 200, json)
+}
+
+// Image names may consist of multiple path components, e.g. team/project/image:tag
+func getImageAndTag(paths []string) (string, string) {
+	s := strings.Join(paths, "/")
+
 	if len(s) == 0 {
 		return "*", ""
 	}
@@ -1,9 +1,13 @@
 package server
 
 import (
+	"errors"
+	"mosi-docker-registry/pkg/json"
+	"net/http"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func concatImageAndTag(img, tag string) string {
@@ -29,4 +33,36 @@ func TestImageAndTagFromPaths(t *testing.T) {
 	assert.Equal(concatImageAndTag("*", "*"), concatImageAndTag(getImageAndTag(paths)))
 	paths[0] = "*abc*:*abc*"
 	assert.Equal(concatImageAndTag("*abc*", "*abc*"), concatImageAndTag(getImageAndTag(paths)))
+
+	paths = []string{"team", "project", "image:1.*"}
+	assert.Equal(concatImageAndTag("team/project/image", "1.*"), concatImageAndTag(getImageAndTag(paths)))
+	paths = []string{"team", "*"}
+	assert.Equal(concatImageAndTag("team/*", ""), concatImageAndTag(getImageAndTag(paths)))
+}
+
+// Fails the writes of the response body like a client which went away
+type failingResponseWriter struct {
+	header http.Header
+}
+
+func (w *failingResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *failingResponseWriter) Write(b []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func (w *failingResponseWriter) WriteHeader(status int) {
+}
+
+func TestExportAbort(t *testing.T) {
+	c := newConformance(t)
+	require.Equal(t, 201, c.pushManifest("conformance/app", "1.0", c.pushImage("conformance/app", []byte("layer"))).StatusCode)
+
+	args := json.NewJsonObject()
+	args.Put("refs", json.JsonArrayFromStrings("conformance/app:1.0"))
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		cliHandleGetExport(&failingResponseWriter{header: http.Header{}}, args)
+	})
 }
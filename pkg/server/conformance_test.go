@@ -0,0 +1,520 @@
+package server
+
+// Runs the pull, push, content discovery and content management workflows of the OCI distribution spec
+// and the docker token authentication against the server's handlers.
+// https://github.com/opencontainers/distribution-spec/blob/main/spec.md
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"mosi-docker-registry/pkg/config"
+	"mosi-docker-registry/pkg/json"
+	"mosi-docker-registry/pkg/repo"
+	"mosi-docker-registry/pkg/storage"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const conformanceConfig = `{
+	"repo": {
+		"dir": "repo",
+		"driver": "%s",
+		"allowAnonymousPull": true,
+		"uploadMaxIdleMinutes": 0,
+		"manifestMaxSizeKiB": 64,
+		"quotas": [{"name": "conformance/quota", "maxSizeMiB": 1}]
+	},
+	"accounts": [
+		{"usr": "admin", "pwd": "admin", "admin": true, "images": [{"name": "*", "pull": true, "push": true, "delete": true}]},
+		{"usr": "ci", "pwd": "ci", "images": [{"name": "conformance/*", "pull": true, "push": true}]},
+		{"usr": "anonymous", "pwd": "", "images": [{"name": "conformance/public", "pull": true}]}
+	]
+}`
+
+// The storage driver of the repository
+var conformanceDriver = storage.DriverFilesystem
+
+type conformance struct {
+	t   *testing.T
+	srv *httptest.Server
+	usr string
+	pwd string
+}
+
+func newConformance(t *testing.T) *conformance {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "config.json")
+	require.Nil(t, os.WriteFile(fn, []byte(fmt.Sprintf(conformanceConfig, conformanceDriver)), 0600))
+	require.True(t, config.ReadIfExists(dir, fn))
+
+	srv := httptest.NewServer(newHandler())
+	t.Cleanup(srv.Close)
+	return &conformance{t: t, srv: srv, usr: "admin", pwd: "admin"}
+}
+
+func (c *conformance) do(method, path string, body []byte, header map[string]string) *http.Response {
+	req, err := http.NewRequest(method, c.srv.URL+path, bytes.NewReader(body))
+	require.Nil(c.t, err)
+	if len(c.usr) > 0 {
+		req.SetBasicAuth(c.usr, c.pwd)
+	}
+	for key, val := range header {
+		req.Header.Set(key, val)
+	}
+	rsp, err := http.DefaultClient.Do(req)
+	require.Nil(c.t, err)
+	c.t.Cleanup(func() { rsp.Body.Close() })
+	return rsp
+}
+
+func (c *conformance) body(rsp *http.Response) []byte {
+	body, err := io.ReadAll(rsp.Body)
+	require.Nil(c.t, err)
+	return body
+}
+
+// Returns the code of the first error of an error response
+func (c *conformance) errorCode(rsp *http.Response) string {
+	rspJson, err := json.DecodeBytes(c.body(rsp))
+	require.Nil(c.t, err)
+	return rspJson.GetArray("errors", json.NewJsonArray(0)).GetObject(0, json.NewJsonObject()).GetString("code", "")
+}
+
+// The path of the Location header, the server may return absolute URLs
+func (c *conformance) location(rsp *http.Response) string {
+	loc, err := url.Parse(rsp.Header.Get("Location"))
+	require.Nil(c.t, err)
+	require.NotEmpty(c.t, loc.Path)
+	return loc.Path
+}
+
+func (c *conformance) pushBlob(img string, content []byte) string {
+	digest := digestOf(content)
+	rsp := c.do("POST", "/v2/"+img+"/blobs/uploads/?digest="+digest, content, map[string]string{"Content-Type": "application/octet-stream"})
+	require.Equal(c.t, 201, rsp.StatusCode)
+	return digest
+}
+
+func (c *conformance) pushManifest(img, reference string, manifest []byte) *http.Response {
+	return c.do("PUT", "/v2/"+img+"/manifests/"+reference, manifest, map[string]string{"Content-Type": mediaTypeOf(manifest)})
+}
+
+// Pushes a config and a layer blob and returns an image manifest referencing them
+func (c *conformance) pushImage(img string, layer []byte) []byte {
+	configDigest := c.pushBlob(img, []byte(`{"architecture":"amd64","os":"linux"}`))
+	layerDigest := c.pushBlob(img, layer)
+	return imageManifest(configDigest, layerDigest, len(layer), "")
+}
+
+func digestOf(content []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+}
+
+func mediaTypeOf(manifest []byte) string {
+	manifestJson, err := json.DecodeBytes(manifest)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return manifestJson.GetString("mediaType", "")
+}
+
+func imageManifest(configDigest, layerDigest string, layerSize int, subject string) []byte {
+	manifest := fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json",`+
+		`"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"%s","size":38},`+
+		`"layers":[{"mediaType":"application/vnd.oci.image.layer.v1.tar+gzip","digest":"%s","size":%d}]`, configDigest, layerDigest, layerSize)
+	if len(subject) > 0 {
+		manifest += fmt.Sprintf(`,"artifactType":"application/vnd.example.sbom","subject":{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"%s","size":1}`, subject)
+	}
+	return []byte(manifest + "}")
+}
+
+func TestConformancePull(t *testing.T) {
+	assert := assert.New(t)
+	c := newConformance(t)
+
+	img := "conformance/pull"
+	layer := []byte("pull layer content")
+	manifest := c.pushImage(img, layer)
+	require.Equal(t, 201, c.pushManifest(img, "v1", manifest).StatusCode)
+	digest := digestOf(manifest)
+
+	for _, reference := range []string{"v1", digest} {
+		rsp := c.do("GET", "/v2/"+img+"/manifests/"+reference, nil, map[string]string{"Accept": "application/vnd.oci.image.manifest.v1+json"})
+		assert.Equal(200, rsp.StatusCode, reference)
+		assert.Equal(digest, rsp.Header.Get("Docker-Content-Digest"))
+		assert.Equal("application/vnd.oci.image.manifest.v1+json", rsp.Header.Get("Content-Type"))
+		assert.Equal(manifest, c.body(rsp))
+
+		rsp = c.do("HEAD", "/v2/"+img+"/manifests/"+reference, nil, nil)
+		assert.Equal(200, rsp.StatusCode, reference)
+		assert.Equal(strconv.Itoa(len(manifest)), rsp.Header.Get("Content-Length"))
+		assert.Empty(c.body(rsp))
+	}
+
+	rsp := c.do("GET", "/v2/"+img+"/manifests/v2", nil, nil)
+	assert.Equal(404, rsp.StatusCode)
+	assert.Equal("MANIFEST_UNKNOWN", c.errorCode(rsp))
+
+	rsp = c.do("HEAD", "/v2/"+img+"/manifests/"+digestOf([]byte("unknown")), nil, nil)
+	assert.Equal(404, rsp.StatusCode)
+
+	layerDigest := digestOf(layer)
+	rsp = c.do("GET", "/v2/"+img+"/blobs/"+layerDigest, nil, nil)
+	assert.Equal(200, rsp.StatusCode)
+	assert.Equal(layerDigest, rsp.Header.Get("Docker-Content-Digest"))
+	assert.Equal(layer, c.body(rsp))
+
+	rsp = c.do("HEAD", "/v2/"+img+"/blobs/"+layerDigest, nil, nil)
+	assert.Equal(200, rsp.StatusCode)
+	assert.Equal(strconv.Itoa(len(layer)), rsp.Header.Get("Content-Length"))
+
+	rsp = c.do("GET", "/v2/"+img+"/blobs/"+layerDigest, nil, map[string]string{"Range": "bytes=5-10"})
+	assert.Equal(206, rsp.StatusCode)
+	assert.Equal(layer[5:11], c.body(rsp))
+
+	rsp = c.do("GET", "/v2/"+img+"/blobs/"+digestOf([]byte("unknown")), nil, nil)
+	assert.Equal(404, rsp.StatusCode)
+	assert.Equal("BLOB_UNKNOWN", c.errorCode(rsp))
+
+	rsp = c.do("GET", "/v2/conformance/unknown/manifests/v1", nil, nil)
+	assert.Equal(404, rsp.StatusCode)
+}
+
+func TestConformancePush(t *testing.T) {
+	assert := assert.New(t)
+	c := newConformance(t)
+
+	img := "conformance/push"
+
+	// POST then PUT
+	content := []byte("monolithic after post")
+	rsp := c.do("POST", "/v2/"+img+"/blobs/uploads/", nil, nil)
+	require.Equal(t, 202, rsp.StatusCode)
+	loc := c.location(rsp)
+	rsp = c.do("PUT", loc+"?digest="+url.QueryEscape(digestOf(content)), content, map[string]string{"Content-Type": "application/octet-stream"})
+	assert.Equal(201, rsp.StatusCode)
+	assert.Equal(digestOf(content), rsp.Header.Get("Docker-Content-Digest"))
+	assert.Equal("/v2/"+img+"/blobs/"+digestOf(content), c.location(rsp))
+
+	// single POST
+	content = []byte("monolithic single post")
+	rsp = c.do("POST", "/v2/"+img+"/blobs/uploads/?digest="+digestOf(content), content, nil)
+	assert.Equal(201, rsp.StatusCode)
+	rsp = c.do("HEAD", "/v2/"+img+"/blobs/"+digestOf(content), nil, nil)
+	assert.Equal(200, rsp.StatusCode)
+
+	// chunked
+	content = []byte("0123456789abcdefghij")
+	rsp = c.do("POST", "/v2/"+img+"/blobs/uploads/", nil, map[string]string{"Content-Length": "0"})
+	require.Equal(t, 202, rsp.StatusCode)
+	loc = c.location(rsp)
+	rsp = c.do("PATCH", loc, content[:10], map[string]string{"Content-Type": "application/octet-stream", "Content-Range": "0-9"})
+	assert.Equal(202, rsp.StatusCode)
+	assert.Equal("0-9", rsp.Header.Get("Range"))
+	loc = c.location(rsp)
+
+	rsp = c.do("GET", loc, nil, nil)
+	assert.Equal(204, rsp.StatusCode)
+	assert.Equal("0-9", rsp.Header.Get("Range"))
+
+	rsp = c.do("PATCH", loc, content[15:], map[string]string{"Content-Range": "15-19"})
+	assert.Equal(416, rsp.StatusCode)
+	assert.Equal("0-9", rsp.Header.Get("Range"))
+
+	rsp = c.do("PATCH", loc, content[10:15], map[string]string{"Content-Range": "10-14"})
+	assert.Equal(202, rsp.StatusCode)
+	assert.Equal("0-14", rsp.Header.Get("Range"))
+
+	rsp = c.do("PUT", loc+"?digest="+url.QueryEscape(digestOf(content)), content[15:], map[string]string{"Content-Range": "15-19"})
+	assert.Equal(201, rsp.StatusCode)
+	rsp = c.do("GET", "/v2/"+img+"/blobs/"+digestOf(content), nil, nil)
+	assert.Equal(content, c.body(rsp))
+
+	// digest mismatch
+	rsp = c.do("POST", "/v2/"+img+"/blobs/uploads/?digest="+digestOf([]byte("other")), []byte("content"), nil)
+	assert.Equal(400, rsp.StatusCode)
+	assert.Equal("DIGEST_INVALID", c.errorCode(rsp))
+
+	// exceeded quota, the session of the single POST is dropped
+	rsp = c.do("POST", "/v2/conformance/quota/blobs/uploads/?digest="+digestOf(make([]byte, 2*1024*1024)), make([]byte, 2*1024*1024), nil)
+	assert.Equal(403, rsp.StatusCode)
+	assert.Equal("DENIED", c.errorCode(rsp))
+	uploads, err := repo.ListUploads("conformance/quota")
+	require.Nil(t, err)
+	assert.Equal(0, uploads.GetArray("tables", nil).GetObject(0, nil).GetArray("rows", nil).Len())
+
+	// cancel
+	rsp = c.do("POST", "/v2/"+img+"/blobs/uploads/", nil, nil)
+	loc = c.location(rsp)
+	rsp = c.do("DELETE", loc, nil, nil)
+	assert.Equal(204, rsp.StatusCode)
+	rsp = c.do("GET", loc, nil, nil)
+	assert.Equal(404, rsp.StatusCode)
+	assert.Equal("BLOB_UPLOAD_UNKNOWN", c.errorCode(rsp))
+
+	// cross repository mount
+	layer := []byte("mounted layer")
+	layerDigest := c.pushBlob(img, layer)
+	rsp = c.do("POST", "/v2/conformance/mounted/blobs/uploads/?mount="+layerDigest+"&from="+img, nil, nil)
+	assert.Equal(201, rsp.StatusCode)
+	assert.Equal("/v2/conformance/mounted/blobs/"+layerDigest, c.location(rsp))
+
+	// a failed mount falls back to an upload session
+	rsp = c.do("POST", "/v2/conformance/mounted/blobs/uploads/?mount="+digestOf([]byte("unknown"))+"&from="+img, nil, nil)
+	assert.Equal(202, rsp.StatusCode)
+	assert.NotEmpty(rsp.Header.Get("Location"))
+
+	// manifests
+	manifest := c.pushImage(img, layer)
+	rsp = c.pushManifest(img, "v1", manifest)
+	assert.Equal(201, rsp.StatusCode)
+	assert.Equal(digestOf(manifest), rsp.Header.Get("Docker-Content-Digest"))
+	assert.Equal("/v2/"+img+"/manifests/"+digestOf(manifest), c.location(rsp))
+
+	rsp = c.pushManifest(img, digestOf(manifest), manifest)
+	assert.Equal(201, rsp.StatusCode)
+
+	rsp = c.pushManifest(img, digestOf([]byte("other")), manifest)
+	assert.Equal(400, rsp.StatusCode)
+	assert.Equal("DIGEST_INVALID", c.errorCode(rsp))
+
+	rsp = c.pushManifest(img, "v2", imageManifest(digestOf([]byte("missing config")), layerDigest, len(layer), ""))
+	assert.Equal(400, rsp.StatusCode)
+	assert.Equal("MANIFEST_BLOB_UNKNOWN", c.errorCode(rsp))
+
+	rsp = c.pushManifest(img, "v2", []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`))
+	assert.Equal(400, rsp.StatusCode)
+	assert.Equal("MANIFEST_INVALID", c.errorCode(rsp))
+
+	rsp = c.pushManifest(img, "v2", bytes.Repeat([]byte(" "), 65*1024))
+	assert.Equal(400, rsp.StatusCode)
+	assert.Equal("MANIFEST_INVALID", c.errorCode(rsp))
+
+	rsp = c.pushManifest(img, "-invalid", manifest)
+	assert.Equal(400, rsp.StatusCode)
+	assert.Equal("TAG_INVALID", c.errorCode(rsp))
+
+	// image index
+	index := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[`+
+		`{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"%s","size":%d,"platform":{"architecture":"amd64","os":"linux"}}]}`,
+		digestOf(manifest), len(manifest)))
+	rsp = c.pushManifest(img, "multi", index)
+	assert.Equal(201, rsp.StatusCode)
+	rsp = c.do("GET", "/v2/"+img+"/manifests/multi", nil, nil)
+	assert.Equal("application/vnd.oci.image.index.v1+json", rsp.Header.Get("Content-Type"))
+	assert.Equal(index, c.body(rsp))
+}
+
+func TestConformanceContentDiscovery(t *testing.T) {
+	assert := assert.New(t)
+	c := newConformance(t)
+
+	img := "conformance/discovery"
+	manifest := c.pushImage(img, []byte("discovery layer"))
+	for _, tag := range []string{"c", "a", "b", "d"} {
+		require.Equal(t, 201, c.pushManifest(img, tag, manifest).StatusCode)
+	}
+
+	rsp := c.do("GET", "/v2/"+img+"/tags/list", nil, nil)
+	assert.Equal(200, rsp.StatusCode)
+	tagsJson, err := json.DecodeBytes(c.body(rsp))
+	require.Nil(t, err)
+	assert.Equal(img, tagsJson.GetString("name", ""))
+	assert.Equal([]string{"a", "b", "c", "d"}, tagsJson.GetArray("tags", nil).ToStringArray(""))
+
+	rsp = c.do("GET", "/v2/"+img+"/tags/list?n=2", nil, nil)
+	assert.Equal(200, rsp.StatusCode)
+	tagsJson, _ = json.DecodeBytes(c.body(rsp))
+	assert.Equal([]string{"a", "b"}, tagsJson.GetArray("tags", nil).ToStringArray(""))
+	link := regexp.MustCompile(`^<(.+)>; rel="next"$`).FindStringSubmatch(rsp.Header.Get("Link"))
+	require.Len(t, link, 2)
+
+	rsp = c.do("GET", link[1], nil, nil)
+	tagsJson, _ = json.DecodeBytes(c.body(rsp))
+	assert.Equal([]string{"c", "d"}, tagsJson.GetArray("tags", nil).ToStringArray(""))
+
+	rsp = c.do("GET", "/v2/"+img+"/tags/list?n=2&last=b", nil, nil)
+	tagsJson, _ = json.DecodeBytes(c.body(rsp))
+	assert.Equal([]string{"c", "d"}, tagsJson.GetArray("tags", nil).ToStringArray(""))
+
+	rsp = c.do("GET", "/v2/conformance/unknown/tags/list", nil, nil)
+	assert.Equal(404, rsp.StatusCode)
+	assert.Equal("NAME_UNKNOWN", c.errorCode(rsp))
+
+	require.Equal(t, 201, c.pushManifest("conformance/other", "v1", c.pushImage("conformance/other", []byte("other layer"))).StatusCode)
+	rsp = c.do("GET", "/v2/_catalog", nil, nil)
+	assert.Equal(200, rsp.StatusCode)
+	catalogJson, _ := json.DecodeBytes(c.body(rsp))
+	assert.Equal([]string{img, "conformance/other"}, catalogJson.GetArray("repositories", nil).ToStringArray(""))
+
+	rsp = c.do("GET", "/v2/_catalog?n=1", nil, nil)
+	catalogJson, _ = json.DecodeBytes(c.body(rsp))
+	assert.Equal([]string{img}, catalogJson.GetArray("repositories", nil).ToStringArray(""))
+	assert.NotEmpty(rsp.Header.Get("Link"))
+
+	// referrers
+	subject := digestOf(manifest)
+	sbomLayer := []byte(`{"spdxVersion":"SPDX-2.3"}`)
+	sbom := c.pushImage(img, sbomLayer)
+	sbomJson, _ := json.DecodeBytes(sbom)
+	sbom = imageManifest(sbomJson.GetObject("config", nil).GetString("digest", ""), digestOf(sbomLayer), len(sbomLayer), subject)
+	rsp = c.pushManifest(img, digestOf(sbom), sbom)
+	assert.Equal(201, rsp.StatusCode)
+	assert.Equal(subject, rsp.Header.Get("OCI-Subject"))
+
+	rsp = c.do("GET", "/v2/"+img+"/referrers/"+subject, nil, nil)
+	assert.Equal(200, rsp.StatusCode)
+	assert.Equal("application/vnd.oci.image.index.v1+json", rsp.Header.Get("Content-Type"))
+	referrersJson, _ := json.DecodeBytes(c.body(rsp))
+	referrers := referrersJson.GetArray("manifests", json.NewJsonArray(0))
+	require.Equal(t, 1, referrers.Len())
+	assert.Equal(digestOf(sbom), referrers.GetObject(0, nil).GetString("digest", ""))
+	assert.Equal("application/vnd.example.sbom", referrers.GetObject(0, nil).GetString("artifactType", ""))
+
+	rsp = c.do("GET", "/v2/"+img+"/referrers/"+subject+"?artifactType=application/vnd.example.signature", nil, nil)
+	assert.Equal(200, rsp.StatusCode)
+	assert.Equal("artifactType", rsp.Header.Get("OCI-Filters-Applied"))
+	referrersJson, _ = json.DecodeBytes(c.body(rsp))
+	assert.Equal(0, referrersJson.GetArray("manifests", json.NewJsonArray(0)).Len())
+
+	rsp = c.do("GET", "/v2/"+img+"/referrers/"+digestOf([]byte("no referrers")), nil, nil)
+	assert.Equal(200, rsp.StatusCode)
+	referrersJson, _ = json.DecodeBytes(c.body(rsp))
+	assert.Equal(0, referrersJson.GetArray("manifests", json.NewJsonArray(0)).Len())
+
+	rsp = c.do("GET", "/v2/conformance/unknown/referrers/"+subject, nil, nil)
+	assert.Equal(200, rsp.StatusCode)
+	referrersJson, _ = json.DecodeBytes(c.body(rsp))
+	assert.Equal(0, referrersJson.GetArray("manifests", json.NewJsonArray(0)).Len())
+}
+
+func TestConformanceContentManagement(t *testing.T) {
+	assert := assert.New(t)
+	c := newConformance(t)
+
+	img := "conformance/management"
+	layer := []byte("management layer")
+	manifest := c.pushImage(img, layer)
+	digest := digestOf(manifest)
+	require.Equal(t, 201, c.pushManifest(img, "v1", manifest).StatusCode)
+	require.Equal(t, 201, c.pushManifest(img, "v2", manifest).StatusCode)
+
+	rsp := c.do("DELETE", "/v2/"+img+"/manifests/v1", nil, nil)
+	assert.Equal(202, rsp.StatusCode)
+	rsp = c.do("GET", "/v2/"+img+"/manifests/v1", nil, nil)
+	assert.Equal(404, rsp.StatusCode)
+	rsp = c.do("GET", "/v2/"+img+"/manifests/v2", nil, nil)
+	assert.Equal(200, rsp.StatusCode)
+
+	rsp = c.do("DELETE", "/v2/"+img+"/manifests/"+digest, nil, nil)
+	assert.Equal(202, rsp.StatusCode)
+	rsp = c.do("GET", "/v2/"+img+"/manifests/"+digest, nil, nil)
+	assert.Equal(404, rsp.StatusCode)
+	rsp = c.do("GET", "/v2/"+img+"/manifests/v2", nil, nil)
+	assert.Equal(404, rsp.StatusCode)
+
+	rsp = c.do("DELETE", "/v2/"+img+"/manifests/"+digest, nil, nil)
+	assert.Equal(404, rsp.StatusCode)
+	assert.Equal("MANIFEST_UNKNOWN", c.errorCode(rsp))
+
+	layerDigest := c.pushBlob(img, layer)
+	rsp = c.do("DELETE", "/v2/"+img+"/blobs/"+layerDigest, nil, nil)
+	assert.Equal(202, rsp.StatusCode)
+	rsp = c.do("GET", "/v2/"+img+"/blobs/"+layerDigest, nil, nil)
+	assert.Equal(404, rsp.StatusCode)
+
+	rsp = c.do("DELETE", "/v2/"+img+"/blobs/"+layerDigest, nil, nil)
+	assert.Equal(404, rsp.StatusCode)
+	assert.Equal("BLOB_UNKNOWN", c.errorCode(rsp))
+}
+
+func TestConformanceAuth(t *testing.T) {
+	assert := assert.New(t)
+	c := newConformance(t)
+
+	img := "conformance/auth"
+	manifest := c.pushImage(img, []byte("auth layer"))
+	require.Equal(t, 201, c.pushManifest(img, "v1", manifest).StatusCode)
+
+	// anonymous requests get challenged with the token realm
+	c.usr, c.pwd = "", ""
+	rsp := c.do("GET", "/v2/", nil, nil)
+	assert.Equal(401, rsp.StatusCode)
+	assert.Equal("UNAUTHORIZED", c.errorCode(rsp))
+	challenge := regexp.MustCompile(`^Bearer realm="([^"]+)", service="([^"]+)"$`).FindStringSubmatch(rsp.Header.Get("WWW-Authenticate"))
+	require.Len(t, challenge, 3)
+	realm, err := url.Parse(challenge[1])
+	require.Nil(t, err)
+	assert.Equal("/v2/token", realm.Path)
+
+	rsp = c.do("GET", "/v2/"+img+"/manifests/v1", nil, nil)
+	assert.Equal(401, rsp.StatusCode)
+	assert.Len(rsp.Header.Values("WWW-Authenticate"), 2)
+
+	rsp = c.do("PUT", "/v2/conformance/public/manifests/v1", manifest, nil)
+	assert.Equal(401, rsp.StatusCode)
+
+	// token flow
+	c.usr, c.pwd = "ci", "ci"
+	rsp = c.do("GET", "/v2/token?service=mosi&scope=repository:"+img+":pull,push", nil, nil)
+	assert.Equal(200, rsp.StatusCode)
+	tokenJson, err := json.DecodeBytes(c.body(rsp))
+	require.Nil(t, err)
+	bearer := map[string]string{"Authorization": "Bearer " + tokenJson.GetString("token", "")}
+
+	c.usr, c.pwd = "", ""
+	rsp = c.do("GET", "/v2/"+img+"/manifests/v1", nil, bearer)
+	assert.Equal(200, rsp.StatusCode)
+	rsp = c.pushManifest(img, "v2", manifest)
+	assert.Equal(401, rsp.StatusCode)
+	rsp = c.do("PUT", "/v2/"+img+"/manifests/v2", manifest, bearer)
+	assert.Equal(201, rsp.StatusCode)
+
+	// authenticated requests without the access rights are denied
+	rsp = c.do("DELETE", "/v2/"+img+"/manifests/v2", nil, bearer)
+	assert.Equal(403, rsp.StatusCode)
+	assert.Equal("DENIED", c.errorCode(rsp))
+	rsp = c.do("PUT", "/v2/other/manifests/v1", manifest, bearer)
+	assert.Equal(403, rsp.StatusCode)
+
+	rsp = c.do("GET", "/v2/", nil, map[string]string{"Authorization": "Bearer DockerToken.unknown"})
+	assert.Equal(401, rsp.StatusCode)
+
+	c.usr, c.pwd = "ci", "wrong"
+	rsp = c.do("GET", "/v2/token?service=mosi&scope=repository:"+img+":pull", nil, nil)
+	assert.NotEqual(200, rsp.StatusCode)
+	rsp = c.do("GET", "/v2/", nil, nil)
+	assert.Equal(401, rsp.StatusCode)
+
+	// basic auth
+	c.usr, c.pwd = "ci", "ci"
+	rsp = c.do("GET", "/v2/", nil, nil)
+	assert.Equal(200, rsp.StatusCode)
+	rsp = c.do("DELETE", "/v2/"+img+"/manifests/v2", nil, nil)
+	assert.Equal(403, rsp.StatusCode)
+
+	c.usr, c.pwd = "admin", "admin"
+	rsp = c.do("DELETE", "/v2/"+img+"/manifests/v2", nil, nil)
+	assert.Equal(202, rsp.StatusCode)
+}
+
+func TestConformanceMemoryDriver(t *testing.T) {
+	conformanceDriver = storage.DriverMemory
+	defer func() { conformanceDriver = storage.DriverFilesystem }()
+
+	t.Run("Pull", TestConformancePull)
+	t.Run("Push", TestConformancePush)
+	t.Run("ContentDiscovery", TestConformanceContentDiscovery)
+	t.Run("ContentManagement", TestConformanceContentManagement)
+}
@@ -1,8 +1,12 @@
 package server
 
 import (
+	"errors"
 	"mosi-docker-registry/pkg/logging"
+	"mosi-docker-registry/pkg/repo"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -30,3 +34,100 @@ func splitPath(r *http.Request) []string {
 	}
 	return ret
 }
+
+// Returns the media types of all Accept headers, e.g. "Accept: application/json; q=0.5, text/plain"
+func getAcceptedMediaTypes(r *http.Request) []string {
+	mediaTypes := []string{}
+	for _, accept := range r.Header.Values("Accept") {
+		for _, s := range strings.Split(accept, ",") {
+			mediaType := strings.TrimSpace(strings.Split(s, ";")[0])
+			if len(mediaType) > 0 {
+				mediaTypes = append(mediaTypes, mediaType)
+			}
+		}
+	}
+	return mediaTypes
+}
+
+// Matches /v2/<name>/<suffix...> where <name> may consist of any number of slash-separated components.
+// A "*" in suffix matches any single path component.
+// Returns the image name and the path components matched by "*".
+func matchImagePath(paths []string, suffix ...string) (string, []string, bool) {
+	n := len(paths) - len(suffix)
+	if n < 2 {
+		return "", nil, false
+	}
+	var vars []string
+	for i, s := range suffix {
+		path := paths[n+i]
+		if s == "*" {
+			vars = append(vars, path)
+		} else if s != path {
+			return "", nil, false
+		}
+	}
+	img := strings.Join(paths[1:n], "/")
+	if !repo.IsValidImageName(img) {
+		return "", nil, false
+	}
+	return img, vars, true
+}
+
+// Returns the pagination query parameters ?n=10&last=xyz, n is -1 if not given
+func getPaginationParams(r *http.Request) (n int, last string, err error) {
+	query := r.URL.Query()
+	n = -1
+	last = query.Get("last")
+	err = nil
+	if s := query.Get("n"); s != "" {
+		n, err = strconv.Atoi(s)
+		if err == nil && n < 0 {
+			err = errors.New("n must not be negative")
+		}
+	}
+	return
+}
+
+// Returns at most n of the lexically sorted entries following last and whether more entries are available.
+// A negative n returns all remaining entries.
+func paginate(entries []string, n int, last string) ([]string, bool) {
+	i := 0
+	if last != "" {
+		i = sort.SearchStrings(entries, last)
+		if i < len(entries) && entries[i] == last {
+			i++
+		}
+	}
+	entries = entries[i:]
+	if n < 0 || n >= len(entries) {
+		return entries, false
+	}
+	return entries[:n], true
+}
+
+// Returns the chunk range of an upload request "Content-Range: 0-1023", start is -1 if not given.
+// Some clients send the HTTP form "bytes 0-1023/*".
+func getContentRange(r *http.Request) (start int64, end int64, err error) {
+	start = -1
+	end = -1
+	err = nil
+	s := r.Header.Get("Content-Range")
+	if s == "" {
+		return
+	}
+	s = strings.TrimPrefix(s, "bytes ")
+	s, _, _ = strings.Cut(s, "/")
+	from, to, ok := strings.Cut(s, "-")
+	if ok {
+		start, err = strconv.ParseInt(from, 10, 64)
+		if err == nil {
+			end, err = strconv.ParseInt(to, 10, 64)
+		}
+	}
+	if !ok || err != nil || start < 0 || end < start {
+		start = -1
+		end = -1
+		err = errors.New("invalid content range: " + r.Header.Get("Content-Range"))
+	}
+	return
+}
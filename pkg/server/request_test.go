@@ -0,0 +1,104 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchImagePath(t *testing.T) {
+	assert := assert.New(t)
+
+	img, vars, ok := matchImagePath([]string{"v2", "image", "manifests", "latest"}, "manifests", "*")
+	assert.True(ok)
+	assert.Equal("image", img)
+	assert.Equal([]string{"latest"}, vars)
+
+	img, vars, ok = matchImagePath([]string{"v2", "team", "project", "image", "blobs", "uploads", "uuid"}, "blobs", "uploads", "*")
+	assert.True(ok)
+	assert.Equal("team/project/image", img)
+	assert.Equal([]string{"uuid"}, vars)
+
+	_, _, ok = matchImagePath([]string{"v2", "manifests", "latest"}, "manifests", "*")
+	assert.False(ok)
+	_, _, ok = matchImagePath([]string{"v2", "image", "blobs", "uploads", "uuid"}, "blobs", "*")
+	assert.False(ok)
+	_, _, ok = matchImagePath([]string{"v2", "Image", "manifests", "latest"}, "manifests", "*")
+	assert.False(ok)
+	_, _, ok = matchImagePath([]string{"v2", "team", "blobs", "image", "manifests", "latest"}, "manifests", "*")
+	assert.False(ok)
+}
+
+func TestPaginate(t *testing.T) {
+	assert := assert.New(t)
+
+	entries := []string{"a", "b", "c", "d"}
+
+	page, more := paginate(entries, -1, "")
+	assert.Equal([]string{"a", "b", "c", "d"}, page)
+	assert.False(more)
+
+	page, more = paginate(entries, 2, "")
+	assert.Equal([]string{"a", "b"}, page)
+	assert.True(more)
+
+	page, more = paginate(entries, 2, "b")
+	assert.Equal([]string{"c", "d"}, page)
+	assert.False(more)
+
+	page, more = paginate(entries, 2, "bb")
+	assert.Equal([]string{"c", "d"}, page)
+	assert.False(more)
+
+	page, more = paginate(entries, 0, "")
+	assert.Equal([]string{}, page)
+	assert.True(more)
+
+	page, more = paginate(entries, 10, "d")
+	assert.Equal([]string{}, page)
+	assert.False(more)
+}
+
+func TestAcceptedMediaTypes(t *testing.T) {
+	assert := assert.New(t)
+
+	r := httptest.NewRequest("GET", "/v2/image/manifests/latest", nil)
+	assert.Equal([]string{}, getAcceptedMediaTypes(r))
+
+	r.Header.Add("Accept", "application/vnd.oci.image.index.v1+json, application/vnd.oci.image.manifest.v1+json;q=0.9")
+	r.Header.Add("Accept", "application/vnd.docker.distribution.manifest.v2+json")
+	assert.Equal([]string{
+		"application/vnd.oci.image.index.v1+json",
+		"application/vnd.oci.image.manifest.v1+json",
+		"application/vnd.docker.distribution.manifest.v2+json",
+	}, getAcceptedMediaTypes(r))
+}
+
+func TestContentRange(t *testing.T) {
+	assert := assert.New(t)
+
+	r := httptest.NewRequest("PATCH", "/v2/image/blobs/uploads/uuid", nil)
+	start, end, err := getContentRange(r)
+	assert.Nil(err)
+	assert.Equal(int64(-1), start)
+	assert.Equal(int64(-1), end)
+
+	r.Header.Set("Content-Range", "0-1023")
+	start, end, err = getContentRange(r)
+	assert.Nil(err)
+	assert.Equal(int64(0), start)
+	assert.Equal(int64(1023), end)
+
+	r.Header.Set("Content-Range", "bytes 1024-2047/*")
+	start, end, err = getContentRange(r)
+	assert.Nil(err)
+	assert.Equal(int64(1024), start)
+	assert.Equal(int64(2047), end)
+
+	for _, invalid := range []string{"1024", "10-5", "-1-5", "a-b"} {
+		r.Header.Set("Content-Range", invalid)
+		_, _, err = getContentRange(r)
+		assert.NotNil(err, invalid)
+	}
+}
@@ -1,10 +1,36 @@
 package server
 
 import (
+	"errors"
+	"fmt"
 	"mosi-docker-registry/pkg/json"
+	"mosi-docker-registry/pkg/logging"
+	"mosi-docker-registry/pkg/repo"
 	"net/http"
+	"net/url"
 )
 
+// Maps the errors of the repo package to the error codes of the distribution spec
+var repoErrors = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{repo.ErrNameUnknown, 404, "NAME_UNKNOWN"},
+	{repo.ErrNameInvalid, 400, "NAME_INVALID"},
+	{repo.ErrBlobUnknown, 404, "BLOB_UNKNOWN"},
+	{repo.ErrBlobUploadUnknown, 404, "BLOB_UPLOAD_UNKNOWN"},
+	{repo.ErrBlobUploadInvalid, 416, "BLOB_UPLOAD_INVALID"},
+	{repo.ErrManifestUnknown, 404, "MANIFEST_UNKNOWN"},
+	{repo.ErrManifestInvalid, 400, "MANIFEST_INVALID"},
+	{repo.ErrManifestBlobUnknown, 400, "MANIFEST_BLOB_UNKNOWN"},
+	{repo.ErrManifestNotAccepted, 404, "MANIFEST_UNKNOWN"},
+	{repo.ErrTagInvalid, 400, "TAG_INVALID"},
+	{repo.ErrDigestInvalid, 400, "DIGEST_INVALID"},
+	{repo.ErrSizeInvalid, 400, "SIZE_INVALID"},
+	{repo.ErrDenied, 403, "DENIED"},
+}
+
 func setDefaultHeader(w http.ResponseWriter) {
 	w.Header().Set("Server", "Mosi Docker Repository/0.1")
 	w.Header().Set("X-Content-Type-Options", "nosniff")
@@ -14,18 +40,59 @@ func setDefaultHeader(w http.ResponseWriter) {
 }
 
 func sendError(w http.ResponseWriter, status int, code, msg string) {
+	sendErrorDetail(w, status, code, msg, nil)
+}
+
+func sendErrorDetail(w http.ResponseWriter, status int, code, msg string, detail any) {
 	errors := json.NewJsonArray(1)
-	errors.Set(0, createError(code, msg))
+	errors.Set(0, createError(code, msg, detail))
 	rsp := json.NewJsonObject()
 	rsp.Put("errors", errors)
 	sendJson(w, status, rsp)
 }
 
-func createError(code, msg string) *json.JsonObject {
+// Sends the error code of a repo error, other errors are logged and sent as internal server errors
+func sendRepoError(w http.ResponseWriter, err error, action string) {
+	for _, repoError := range repoErrors {
+		if errors.Is(err, repoError.err) {
+			sendErrorDetail(w, repoError.status, repoError.code, err.Error(), getRepoErrorDetail(err))
+			return
+		}
+	}
+	logging.Error(LOG, "%s failed: %s", action, err.Error())
+	sendError(w, 500, "UNKNOWN", action+" failed")
+}
+
+// The requested endpoint or method is not part of the API
+func sendUnsupported(w http.ResponseWriter, status int) {
+	setDefaultHeader(w)
+	sendError(w, status, "UNSUPPORTED", "the operation is unsupported")
+}
+
+// Lists the missing digests of a rejected manifest or the usage and limit of an exceeded quota
+func getRepoErrorDetail(err error) any {
+	var blobUnknown *repo.ManifestBlobUnknownError
+	if errors.As(err, &blobUnknown) {
+		detail := json.NewJsonObject()
+		detail.Put("digests", json.JsonArrayFromStrings(blobUnknown.Digests...))
+		return detail
+	}
+	var quotaExceeded *repo.QuotaExceededError
+	if errors.As(err, &quotaExceeded) {
+		detail := json.NewJsonObject()
+		detail.Put("quota", quotaExceeded.Name)
+		detail.Put("usage", quotaExceeded.Usage)
+		detail.Put("limit", quotaExceeded.Limit)
+		return detail
+	}
+	return nil
+}
+
+func createError(code, msg string, detail any) *json.JsonObject {
 	err := json.NewJsonObject()
 	err.Put("code", code)
 	err.Put("message", msg)
-	err.Put("detail", nil)
+	err.Put("detail", detail)
 	return err
 }
 
@@ -34,3 +101,11 @@ func sendJson(w http.ResponseWriter, status int, rsp *json.JsonObject) {
 	w.WriteHeader(status)
 	rsp.EncodeWriter(w)
 }
+
+// Link: </v2/_catalog?last=xyz&n=10>; rel="next"
+func setPaginationLink(w http.ResponseWriter, path string, n int, last string) {
+	query := url.Values{}
+	query.Set("n", fmt.Sprint(n))
+	query.Set("last", last)
+	w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, path, query.Encode()))
+}
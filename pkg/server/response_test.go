@@ -0,0 +1,65 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"mosi-docker-registry/pkg/json"
+	"mosi-docker-registry/pkg/repo"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendRepoError(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{fmt.Errorf("%w: image", repo.ErrNameUnknown), 404, "NAME_UNKNOWN"},
+		{fmt.Errorf("%w: sha256:1234", repo.ErrBlobUnknown), 404, "BLOB_UNKNOWN"},
+		{fmt.Errorf("%w, expected: sha256:1234", repo.ErrDigestInvalid), 400, "DIGEST_INVALID"},
+		{repo.ErrBlobUploadInvalid, 416, "BLOB_UPLOAD_INVALID"},
+		{fmt.Errorf("%w: missing config", repo.ErrManifestInvalid), 400, "MANIFEST_INVALID"},
+		{&repo.ManifestBlobUnknownError{Digests: []string{"sha256:1234"}}, 400, "MANIFEST_BLOB_UNKNOWN"},
+		{errors.New("disk full"), 500, "UNKNOWN"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		sendRepoError(w, test.err, "test")
+		assert.Equal(test.status, w.Code)
+		rsp, err := json.DecodeBytes(w.Body.Bytes())
+		assert.Nil(err)
+		assert.Equal(test.code, rsp.GetArray("errors", nil).GetObject(0, nil).GetString("code", ""))
+	}
+}
+
+func TestSendRepoErrorDetail(t *testing.T) {
+	assert := assert.New(t)
+
+	w := httptest.NewRecorder()
+	sendRepoError(w, &repo.ManifestBlobUnknownError{Digests: []string{"sha256:1234", "sha256:5678"}}, "test")
+	rsp, err := json.DecodeBytes(w.Body.Bytes())
+	assert.Nil(err)
+	detail := rsp.GetArray("errors", nil).GetObject(0, nil).GetObject("detail", nil)
+	assert.Equal([]string{"sha256:1234", "sha256:5678"}, detail.GetArray("digests", nil).ToStringArray(""))
+}
+
+func TestSendRepoErrorQuota(t *testing.T) {
+	assert := assert.New(t)
+
+	w := httptest.NewRecorder()
+	sendRepoError(w, &repo.QuotaExceededError{Name: "team/*", Usage: 2048, Limit: 1024}, "test")
+	assert.Equal(403, w.Code)
+	rsp, err := json.DecodeBytes(w.Body.Bytes())
+	assert.Nil(err)
+	rspErr := rsp.GetArray("errors", nil).GetObject(0, nil)
+	assert.Equal("DENIED", rspErr.GetString("code", ""))
+	detail := rspErr.GetObject("detail", nil)
+	assert.Equal("team/*", detail.GetString("quota", ""))
+	assert.Equal(2048, detail.GetInt("usage", 0))
+	assert.Equal(1024, detail.GetInt("limit", 0))
+}
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"errors"
+	"io"
 	"log"
 	"mosi-docker-registry/pkg/config"
 	"mosi-docker-registry/pkg/json"
@@ -36,17 +38,23 @@ func Start(version string) {
 	}
 	logging.Info(LOG, "Mosi %s address %s://%s, bound %s, repository %s", version, protocol, servAddr, bindAddr, config.RepoDir())
 
-	http.HandleFunc(config.ServerPath()+"/", route)       // trailing / is required
-	http.HandleFunc(config.ServerTokenPath(), routeToken) // trailing / not allowed, otherwise all /v2/token?xxx requests get redirected
+	err := repo.CheckStorage()
+	if err != nil {
+		logging.Fatal(LOG, "%s", err.Error())
+	}
+	repo.Recover()
+	repo.Migrate()
+	repo.StartUploadReaper()
+	repo.StartRetention()
 
 	serverErrorWriter := &serverErrorWriter{}
 	serverErrorLogger := log.New(serverErrorWriter, "", 0)
 
 	srv := &http.Server{
 		Addr:     bindAddr,
+		Handler:  newHandler(),
 		ErrorLog: serverErrorLogger,
 	}
-	var err error
 	if config.TlsEnabled() {
 		err = srv.ListenAndServeTLS(config.TlsCrtFile(), config.TlsKeyFile())
 	} else {
@@ -57,6 +65,14 @@ func Start(version string) {
 	}
 }
 
+// Routes the registry API and the token endpoint
+func newHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(config.ServerPath()+"/", route)       // trailing / is required
+	mux.HandleFunc(config.ServerTokenPath(), routeToken) // trailing / not allowed, otherwise all /v2/token?xxx requests get redirected
+	return mux
+}
+
 func route(w http.ResponseWriter, r *http.Request) {
 	printRequest(r)
 
@@ -74,7 +90,7 @@ func route(w http.ResponseWriter, r *http.Request) {
 	case "DELETE":
 		handleDelete(w, r)
 	default:
-		w.WriteHeader(404)
+		sendUnsupported(w, 405)
 	}
 }
 
@@ -85,14 +101,14 @@ func routeToken(w http.ResponseWriter, r *http.Request) {
 	case "GET":
 		handleGetToken(w, r)
 	default:
-		w.WriteHeader(404)
+		sendUnsupported(w, 405)
 	}
 }
 
 func handleGetToken(w http.ResponseWriter, r *http.Request) {
 	token := createAndStoreTokenFromBasicAuth(r)
 	if token == "" {
-		w.WriteHeader(403)
+		sendDenied(w)
 		return
 	}
 
@@ -115,15 +131,39 @@ func handleGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// /v2/_catalog
+	if len(paths) == 2 && paths[1] == "_catalog" {
+		handleGetCatalog(w, r)
+		return
+	}
+
 	// /v2/imagename/blobs/digest
-	if len(paths) == 4 && paths[2] == "blobs" {
-		handleGetBlob(w, r)
+	if img, vars, ok := matchImagePath(paths, "blobs", "*"); ok {
+		handleGetBlob(w, r, img, vars[0])
+		return
+	}
+
+	// /v2/imagename/blobs/uploads/uploadUuid
+	if img, vars, ok := matchImagePath(paths, "blobs", "uploads", "*"); ok {
+		handleGetBlobUpload(w, r, img, vars[0])
+		return
+	}
+
+	// /v2/imagename/manifests/reference
+	if img, vars, ok := matchImagePath(paths, "manifests", "*"); ok {
+		handleGetManifest(w, r, img, vars[0])
 		return
 	}
 
-	// /v2/imagename/manifests/digest
-	if len(paths) == 4 && paths[2] == "manifests" {
-		handleGetManifest(w, r)
+	// /v2/imagename/tags/list
+	if img, _, ok := matchImagePath(paths, "tags", "list"); ok {
+		handleGetTags(w, r, img)
+		return
+	}
+
+	// /v2/imagename/referrers/digest
+	if img, vars, ok := matchImagePath(paths, "referrers", "*"); ok {
+		handleGetReferrers(w, r, img, vars[0])
 		return
 	}
 
@@ -133,63 +173,149 @@ func handleGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.WriteHeader(404)
+	sendUnsupported(w, 404)
 }
 
-func handleGetBlob(w http.ResponseWriter, r *http.Request) {
-	paths := splitPath(r)
-	img := paths[1]
-	digest := paths[3]
+// Lists the images the request is allowed to pull
+func handleGetCatalog(w http.ResponseWriter, r *http.Request) {
+	token := getRequestToken(r, true)
+	if token == nil {
+		sendUnauthorized(w, r, true)
+		return
+	}
+
+	setDefaultHeader(w)
 
+	n, last, err := getPaginationParams(r)
+	if err != nil {
+		sendError(w, 400, "PAGINATION_NUMBER_INVALID", "invalid number of results requested")
+		return
+	}
+
+	imgs, err := repo.GetImages()
+	if err != nil {
+		sendRepoError(w, err, "get images")
+		return
+	}
+
+	allowedImgs := []string{}
+	for _, img := range imgs {
+		if checkTokenAccessRights(token, img, false, false, false) {
+			allowedImgs = append(allowedImgs, img)
+		}
+	}
+
+	allowedImgs, more := paginate(allowedImgs, n, last)
+	if more && len(allowedImgs) > 0 {
+		setPaginationLink(w, r.URL.Path, n, allowedImgs[len(allowedImgs)-1])
+	}
+
+	rsp := json.NewJsonObject()
+	rsp.Put("repositories", json.JsonArrayFromStrings(allowedImgs...))
+	sendJson(w, 200, rsp)
+}
+
+func handleGetBlob(w http.ResponseWriter, r *http.Request, img, digest string) {
 	if !checkPullAuth(w, r, img) {
 		return
 	}
 
 	setDefaultHeader(w)
 
-	repo.DownloadBlob(img, digest, w)
+	err := repo.DownloadBlob(img, digest, w, r)
+	if err != nil {
+		sendRepoError(w, err, "download blob")
+	}
 }
 
-func handleGetManifest(w http.ResponseWriter, r *http.Request) {
-	paths := splitPath(r)
-	img := paths[1]
-	digest := paths[3]
-
+func handleGetManifest(w http.ResponseWriter, r *http.Request, img, reference string) {
 	if !checkPullAuth(w, r, img) {
 		return
 	}
 
 	setDefaultHeader(w)
 
-	repo.DownloadManifest(img, digest, w)
+	err := repo.DownloadManifest(img, reference, getAcceptedMediaTypes(r), w)
+	if err != nil {
+		sendRepoError(w, err, "download manifest")
+	}
 }
 
-func handleHead(w http.ResponseWriter, r *http.Request) {
-	paths := splitPath(r)
-	img := paths[1]
+func handleGetTags(w http.ResponseWriter, r *http.Request, img string) {
+	if !checkPullAuth(w, r, img) {
+		return
+	}
+
+	setDefaultHeader(w)
+
+	n, last, err := getPaginationParams(r)
+	if err != nil {
+		sendError(w, 400, "PAGINATION_NUMBER_INVALID", "invalid number of results requested")
+		return
+	}
+
+	tags, err := repo.GetImageTags(img)
+	if err != nil {
+		sendRepoError(w, err, "get image tags")
+		return
+	}
+
+	tags, more := paginate(tags, n, last)
+	if more && len(tags) > 0 {
+		setPaginationLink(w, r.URL.Path, n, tags[len(tags)-1])
+	}
+
+	rsp := json.NewJsonObject()
+	rsp.Put("name", img)
+	rsp.Put("tags", json.JsonArrayFromStrings(tags...))
+	sendJson(w, 200, rsp)
+}
 
+// Lists the manifests referring to a subject manifest as an image index, optionally filtered by ?artifactType=
+func handleGetReferrers(w http.ResponseWriter, r *http.Request, img, digest string) {
 	if !checkPullAuth(w, r, img) {
 		return
 	}
 
+	setDefaultHeader(w)
+
+	artifactType := r.URL.Query().Get("artifactType")
+
+	index, err := repo.GetReferrers(img, digest, artifactType)
+	if err != nil {
+		sendRepoError(w, err, "get referrers")
+		return
+	}
+
+	if len(artifactType) > 0 {
+		w.Header().Set("OCI-Filters-Applied", "artifactType")
+	}
+	w.Header().Set("Content-Type", repo.MediaTypeOciIndex)
+	w.WriteHeader(200)
+	index.EncodeWriter(w)
+}
+
+func handleHead(w http.ResponseWriter, r *http.Request) {
+	paths := splitPath(r)
+
 	// /v2/imagename/blobs/digest
-	if len(paths) == 4 && paths[2] == "blobs" {
-		handleHeadBlob(w, r)
+	if img, vars, ok := matchImagePath(paths, "blobs", "*"); ok {
+		handleHeadBlob(w, r, img, vars[0])
 		return
 	}
 
-	// /v2/imagename/manifests/latest
-	if len(paths) == 4 && paths[2] == "manifests" {
-		handleHeadManifest(w, r)
+	// /v2/imagename/manifests/reference
+	if img, vars, ok := matchImagePath(paths, "manifests", "*"); ok {
+		handleHeadManifest(w, r, img, vars[0])
 		return
 	}
-	w.WriteHeader(404)
+	sendUnsupported(w, 404)
 }
 
-func handleHeadBlob(w http.ResponseWriter, r *http.Request) {
-	paths := splitPath(r)
-	img := paths[1]
-	digest := paths[3]
+func handleHeadBlob(w http.ResponseWriter, r *http.Request, img, digest string) {
+	if !checkPullAuth(w, r, img) {
+		return
+	}
 
 	exists, len, modified := repo.ExistsBlob(img, digest)
 
@@ -197,6 +323,7 @@ func handleHeadBlob(w http.ResponseWriter, r *http.Request) {
 		setDefaultHeader(w)
 
 		w.Header().Set("Docker-Content-Digest", digest)
+		w.Header().Set("ETag", repo.GetBlobETag(digest))
 		w.Header().Set("Accept-Ranges", "bytes")
 		w.Header().Set("Content-Type", "application/vnd.docker.image.rootfs.diff.tar.gzip")
 		w.Header().Set("Last-Modified", modified)
@@ -205,41 +332,54 @@ func handleHeadBlob(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(200)
 	} else {
 		setDefaultHeader(w)
-		w.WriteHeader(404)
+		sendError(w, 404, "BLOB_UNKNOWN", repo.ErrBlobUnknown.Error())
 	}
 }
 
-func handleHeadManifest(w http.ResponseWriter, r *http.Request) {
-	paths := splitPath(r)
-	img := paths[1]
-	tag := paths[3]
+func handleHeadManifest(w http.ResponseWriter, r *http.Request, img, reference string) {
+	if !checkPullAuth(w, r, img) {
+		return
+	}
 
-	exists, len, modified, digest := repo.ExistsManifest(img, tag)
+	exists, len, modified, digest, mediaType := repo.ExistsManifest(img, reference)
+
+	if exists && !repo.IsMediaTypeAccepted(getAcceptedMediaTypes(r), mediaType) {
+		setDefaultHeader(w)
+		sendError(w, 404, "MANIFEST_UNKNOWN", repo.ErrManifestNotAccepted.Error()+": "+mediaType)
+		return
+	}
 
 	if exists {
 		setDefaultHeader(w)
 
 		w.Header().Set("Docker-Content-Digest", digest)
-		w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+		w.Header().Set("Content-Type", mediaType)
 		w.Header().Set("Last-Modified", modified)
 		w.Header().Set("Content-Length", strconv.FormatInt(len, 10))
 
 		w.WriteHeader(200)
 	} else {
 		setDefaultHeader(w)
-		w.WriteHeader(404)
+		sendError(w, 404, "MANIFEST_UNKNOWN", repo.ErrManifestUnknown.Error())
 	}
 }
 
 func handlePost(w http.ResponseWriter, r *http.Request) {
 	// /v2/imagename/blobs/uploads
+	// /v2/imagename/blobs/uploads?digest=digest
+	// /v2/imagename/blobs/uploads?mount=digest&from=imagename
 	paths := splitPath(r)
-	if len(paths) != 4 || paths[2] != "blobs" || paths[3] != "uploads" {
-		w.WriteHeader(404)
+
+	// /v2/cli/...
+	if len(paths) > 1 && paths[1] == "cli" {
+		cliHandlePost(w, r)
+		return
+	}
+	img, _, ok := matchImagePath(paths, "blobs", "uploads")
+	if !ok {
+		sendUnsupported(w, 404)
 		return
 	}
-
-	img := paths[1]
 
 	if !checkPushAuth(w, r, img) {
 		return
@@ -247,11 +387,56 @@ func handlePost(w http.ResponseWriter, r *http.Request) {
 
 	setDefaultHeader(w)
 
-	uploadUuid := repo.CreateBlobUploadUuid()
-	uploadPath := repo.GetBlobUploadUrlPath(img, uploadUuid)
-	w.Header().Set("Range", "0-0")
-	w.Header().Set("Docker-Upload-UUID", uploadUuid)
-	w.Header().Set("Location", uploadPath)
+	body, err := getQuotaReader(r, img)
+	if err != nil {
+		sendRepoError(w, err, "check quota")
+		return
+	}
+
+	query := r.URL.Query()
+
+	// without pull access to the source image or if it does not have the blob, an upload session is started instead
+	if mount, from := query.Get("mount"), query.Get("from"); mount != "" && from != "" && checkRequestAuth(r, from, true, false, false, false) {
+		len, uri, err := repo.MountBlob(img, from, mount, getRequestUsr(r), r)
+		if err == nil {
+			w.Header().Set("Docker-Content-Digest", mount)
+			w.Header().Set("Location", uri)
+			w.Header().Set("Content-Length", "0")
+			w.WriteHeader(201)
+			logging.Debug(LOG, "mounted blob %s from %s into %s, %d bytes", mount, from, img, len)
+			return
+		}
+		if errors.Is(err, repo.ErrDenied) {
+			sendRepoError(w, err, "mount blob")
+			return
+		}
+		if !errors.Is(err, repo.ErrBlobUnknown) && !errors.Is(err, repo.ErrDigestInvalid) {
+			logging.Error(LOG, "mount blob failed: %s", err.Error())
+		}
+	}
+
+	uploadUuid, err := repo.CreateBlobUpload(img)
+	if err != nil {
+		sendRepoError(w, err, "create blob upload")
+		return
+	}
+
+	// monolithic upload of the whole blob with the POST
+	if digest := query.Get("digest"); digest != "" {
+		// the session is not reported to the client, so it is dropped when the upload fails
+		_, err := repo.UploadBlobChunk(img, uploadUuid, -1, -1, body)
+		if err != nil {
+			cancelBlobUpload(img, uploadUuid)
+			sendRepoError(w, err, "upload blob")
+			return
+		}
+		if !completeBlobUpload(w, r, img, uploadUuid, digest) {
+			cancelBlobUpload(img, uploadUuid)
+		}
+		return
+	}
+
+	setBlobUploadHeader(w, img, uploadUuid, 0)
 	w.Header().Set("Content-Length", "0")
 
 	w.WriteHeader(202)
@@ -260,73 +445,150 @@ func handlePost(w http.ResponseWriter, r *http.Request) {
 func handlePatch(w http.ResponseWriter, r *http.Request) {
 	// /v2/imagename/blobs/uploads/uploadUuid
 	paths := splitPath(r)
-	if len(paths) != 5 || paths[2] != "blobs" || paths[3] != "uploads" {
-		w.WriteHeader(404)
+	img, vars, ok := matchImagePath(paths, "blobs", "uploads", "*")
+	if !ok {
+		sendUnsupported(w, 404)
 		return
 	}
 
-	img := paths[1]
-	uploadUuid := paths[4]
+	uploadUuid := vars[0]
 
 	if !checkPushAuth(w, r, img) {
 		return
 	}
 
-	uploadPath := repo.GetBlobUploadUrlPath(img, uploadUuid)
-
 	setDefaultHeader(w)
 
-	len, err := repo.UploadBlob(img, uploadUuid, r.Body)
+	start, end, err := getContentRange(r)
+	if err != nil {
+		sendError(w, 416, "BLOB_UPLOAD_INVALID", err.Error())
+		return
+	}
 
+	body, err := getQuotaReader(r, img)
 	if err != nil {
-		logging.Error(LOG, "upload blob failed: %s", err.Error())
-		w.WriteHeader(500)
+		sendRepoError(w, err, "check quota")
 		return
 	}
 
-	w.Header().Set("Range", "0-"+strconv.FormatInt(len-1, 10))
-	w.Header().Set("Docker-Upload-UUID", uploadUuid)
-	w.Header().Set("Location", uploadPath)
+	size, err := repo.UploadBlobChunk(img, uploadUuid, start, end, body)
+	if err != nil {
+		sendBlobUploadError(w, img, uploadUuid, size, err)
+		return
+	}
+
+	setBlobUploadHeader(w, img, uploadUuid, size)
 	w.Header().Set("Content-Length", "0")
 
 	w.WriteHeader(202)
 }
 
-func handlePut(w http.ResponseWriter, r *http.Request) {
-	paths := splitPath(r)
-	img := paths[1]
-
+// Reports the progress of an upload session so that clients can resume an interrupted upload
+func handleGetBlobUpload(w http.ResponseWriter, r *http.Request, img, uploadUuid string) {
 	if !checkPushAuth(w, r, img) {
 		return
 	}
 
+	setDefaultHeader(w)
+
+	size, err := repo.GetBlobUploadSize(img, uploadUuid)
+	if err != nil {
+		sendBlobUploadError(w, img, uploadUuid, size, err)
+		return
+	}
+
+	setBlobUploadHeader(w, img, uploadUuid, size)
+	w.Header().Set("Content-Length", "0")
+
+	w.WriteHeader(204)
+}
+
+// Range: 0-1023 is the range of bytes received so far, an empty upload reports 0-0 like the reference registry
+func setBlobUploadHeader(w http.ResponseWriter, img, uploadUuid string, size int64) {
+	end := size - 1
+	if end < 0 {
+		end = 0
+	}
+	w.Header().Set("Range", "0-"+strconv.FormatInt(end, 10))
+	w.Header().Set("Docker-Upload-UUID", uploadUuid)
+	w.Header().Set("Location", repo.GetBlobUploadUrlPath(img, uploadUuid))
+}
+
+// An out of order chunk is answered with the current range so that the client can continue from there
+func sendBlobUploadError(w http.ResponseWriter, img, uploadUuid string, size int64, err error) {
+	if errors.Is(err, repo.ErrBlobUploadInvalid) || errors.Is(err, repo.ErrSizeInvalid) {
+		setBlobUploadHeader(w, img, uploadUuid, size)
+	}
+	sendRepoError(w, err, "upload blob")
+}
+
+// Limits the pushed body to the quotas of the image and of the request's account
+func getQuotaReader(r *http.Request, img string) (io.Reader, error) {
+	return repo.QuotaReader(img, getRequestUsr(r), r.Body)
+}
+
+// Returns the account of the request, "" if it has none
+func getRequestUsr(r *http.Request) string {
+	if token := getRequestToken(r, false); token != nil {
+		return token.usr
+	}
+	return ""
+}
+
+func handlePut(w http.ResponseWriter, r *http.Request) {
+	paths := splitPath(r)
+
 	// /v2/imagename/blobs/uploads/uploadUid?digest=sha256%3A2279fc1f015f997d179c41693a6903e195f012a8dbe390d15bbc2f292b2da996
-	if len(paths) == 5 && paths[2] == "blobs" && paths[3] == "uploads" {
-		handlePutBlob(w, r)
+	if img, vars, ok := matchImagePath(paths, "blobs", "uploads", "*"); ok {
+		handlePutBlob(w, r, img, vars[0])
 		return
 	}
 
-	// /v2/imagename/manifests/tag
-	if len(paths) == 4 && paths[2] == "manifests" {
-		handlePutManifest(w, r)
+	// /v2/imagename/manifests/reference
+	if img, vars, ok := matchImagePath(paths, "manifests", "*"); ok {
+		handlePutManifest(w, r, img, vars[0])
 		return
 	}
-	w.WriteHeader(404)
+	sendUnsupported(w, 404)
 }
 
-func handlePutBlob(w http.ResponseWriter, r *http.Request) {
+func handlePutBlob(w http.ResponseWriter, r *http.Request, img, uploadUuid string) {
+	if !checkPushAuth(w, r, img) {
+		return
+	}
+
 	query := r.URL.Query()
-	paths := splitPath(r)
-	img := paths[1]
-	uploadUuid := paths[4]
 	digest := query.Get("digest")
 
 	setDefaultHeader(w)
 
+	// the final chunk may come with the PUT
+	start, end, err := getContentRange(r)
+	if err != nil {
+		sendError(w, 416, "BLOB_UPLOAD_INVALID", err.Error())
+		return
+	}
+
+	body, err := getQuotaReader(r, img)
+	if err != nil {
+		sendRepoError(w, err, "check quota")
+		return
+	}
+
+	size, err := repo.UploadBlobChunk(img, uploadUuid, start, end, body)
+	if err != nil {
+		sendBlobUploadError(w, img, uploadUuid, size, err)
+		return
+	}
+
+	completeBlobUpload(w, r, img, uploadUuid, digest)
+}
+
+// Moves the uploaded blob to the image's blobs if its content matches digest
+func completeBlobUpload(w http.ResponseWriter, r *http.Request, img, uploadUuid, digest string) (ok bool) {
 	len, uri, digest, err := repo.PutBlob(img, uploadUuid, digest, r)
 	if err != nil {
-		logging.Error(LOG, "put blob failed: %s", err.Error())
-		w.WriteHeader(500)
+		sendRepoError(w, err, "put blob")
 		return
 	}
 
@@ -335,26 +597,38 @@ func handlePutBlob(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Location", uri)
 
 	w.WriteHeader(201)
+	return true
 }
 
-func handlePutManifest(w http.ResponseWriter, r *http.Request) {
-	paths := splitPath(r)
-	img := paths[1]
-	tag := paths[3]
+// Drops an upload session which failed, it may be gone already
+func cancelBlobUpload(img, uploadUuid string) {
+	err := repo.CancelBlobUpload(img, uploadUuid)
+	if err != nil && !errors.Is(err, repo.ErrBlobUploadUnknown) {
+		logging.Error(LOG, "cancel blob upload failed: %s", err.Error())
+	}
+}
+
+func handlePutManifest(w http.ResponseWriter, r *http.Request, img, reference string) {
+	if !checkPushAuth(w, r, img) {
+		return
+	}
 
 	setDefaultHeader(w)
 
-	digest, modified, content, err := repo.UploadManifest(img, tag, r.Body)
+	digest, mediaType, modified, content, err := repo.UploadManifest(img, reference, r.Body)
 
 	if err != nil {
-		logging.Error(LOG, "upload manifest failed: %s", err.Error())
-		w.WriteHeader(500)
+		sendRepoError(w, err, "upload manifest")
 		return
 	}
 
 	w.Header().Set("Last-Modified", modified)
 	w.Header().Set("Docker-Content-Digest", digest)
-	w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+	w.Header().Set("Location", config.ServerPath()+"/"+img+"/manifests/"+digest)
+	if subject := repo.GetManifestSubject(content); len(subject) > 0 {
+		w.Header().Set("OCI-Subject", subject)
+	}
+	w.Header().Set("Content-Type", mediaType)
 	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
 
 	w.WriteHeader(201)
@@ -368,11 +642,80 @@ func handlePutManifest(w http.ResponseWriter, r *http.Request) {
 func handleDelete(w http.ResponseWriter, r *http.Request) {
 	paths := splitPath(r)
 
+	// /v2/imagename/manifests/reference
+	if img, vars, ok := matchImagePath(paths, "manifests", "*"); ok {
+		handleDeleteManifest(w, r, img, vars[0])
+		return
+	}
+
+	// /v2/imagename/blobs/digest
+	if img, vars, ok := matchImagePath(paths, "blobs", "*"); ok {
+		handleDeleteBlob(w, r, img, vars[0])
+		return
+	}
+
+	// /v2/imagename/blobs/uploads/uploadUuid
+	if img, vars, ok := matchImagePath(paths, "blobs", "uploads", "*"); ok {
+		handleDeleteBlobUpload(w, r, img, vars[0])
+		return
+	}
+
 	// /v2/cli/...
 	if len(paths) > 1 && paths[1] == "cli" {
 		cliHandleDelete(w, r)
 		return
 	}
 
-	w.WriteHeader(404)
+	sendUnsupported(w, 404)
+}
+
+func handleDeleteManifest(w http.ResponseWriter, r *http.Request, img, reference string) {
+	if !checkDeleteAuth(w, r, img) {
+		return
+	}
+
+	setDefaultHeader(w)
+
+	err := repo.DeleteManifest(img, reference)
+	if err != nil {
+		sendRepoError(w, err, "delete manifest")
+		return
+	}
+
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(202)
+}
+
+func handleDeleteBlob(w http.ResponseWriter, r *http.Request, img, digest string) {
+	if !checkDeleteAuth(w, r, img) {
+		return
+	}
+
+	setDefaultHeader(w)
+
+	err := repo.DeleteBlob(img, digest)
+	if err != nil {
+		sendRepoError(w, err, "delete blob")
+		return
+	}
+
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(202)
+}
+
+// Cancels an upload session
+func handleDeleteBlobUpload(w http.ResponseWriter, r *http.Request, img, uploadUuid string) {
+	if !checkPushAuth(w, r, img) {
+		return
+	}
+
+	setDefaultHeader(w)
+
+	err := repo.CancelBlobUpload(img, uploadUuid)
+	if err != nil {
+		sendRepoError(w, err, "cancel blob upload")
+		return
+	}
+
+	w.WriteHeader(204)
 }
@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Stores files in a directory of the local file system, the repository layout of older Mosi versions.
+// New files are written to a temporary file next to them, synced and renamed, so readers see either the old or the new file.
+
+// Temporary files are hidden and removed by Recover after a crash, e.g. v2/app/manifests/.latest.123456.tmp
+const tempSuffix = ".tmp"
+
+type filesystem struct {
+	root string
+}
+
+func NewFilesystem(root string) Driver {
+	return &filesystem{root: root}
+}
+
+func (d *filesystem) Name() string {
+	return DriverFilesystem
+}
+
+func (d *filesystem) fn(path string) string {
+	return filepath.Join(d.root, filepath.FromSlash(path))
+}
+
+func (d *filesystem) Stat(path string) (FileInfo, error) {
+	fileInfo, err := os.Stat(d.fn(path))
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return FileInfo{Path: path, Size: fileInfo.Size(), Modified: fileInfo.ModTime(), IsDir: fileInfo.IsDir()}, nil
+}
+
+func (d *filesystem) Reader(path string) (io.ReadSeekCloser, error) {
+	return os.Open(d.fn(path))
+}
+
+func (d *filesystem) Writer(path string, append bool) (FileWriter, error) {
+	fn := d.fn(path)
+	if append {
+		f, err := os.OpenFile(fn, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return nil, err
+		}
+		fileInfo, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &filesystemWriter{f: f, offset: fileInfo.Size(), size: fileInfo.Size()}, nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(fn), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(filepath.Dir(fn), "."+filepath.Base(fn)+".*"+tempSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return &filesystemWriter{f: f, fn: fn, offset: -1}, nil
+}
+
+func (d *filesystem) Move(src, dst string) error {
+	dstFn := d.fn(dst)
+	err := os.MkdirAll(filepath.Dir(dstFn), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(d.fn(src), dstFn)
+	if err != nil {
+		return err
+	}
+	err = syncDir(filepath.Dir(dstFn))
+	if err != nil {
+		return err
+	}
+	return d.deleteEmptyDirs(filepath.Dir(d.fn(src)))
+}
+
+func (d *filesystem) Delete(path string) error {
+	fn := d.fn(path)
+	if _, err := os.Stat(fn); err != nil {
+		return err
+	}
+	err := os.RemoveAll(fn)
+	if err != nil {
+		return err
+	}
+	return d.deleteEmptyDirs(filepath.Dir(fn))
+}
+
+// Directories are implicit, so they get deleted with their last file
+func (d *filesystem) deleteEmptyDirs(dir string) error {
+	for strings.HasPrefix(dir, d.root+string(filepath.Separator)) {
+		err := os.Remove(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			dir = filepath.Dir(dir)
+			continue
+		}
+		if err != nil {
+			// not empty
+			return nil
+		}
+		dir = filepath.Dir(dir)
+	}
+	return nil
+}
+
+func (d *filesystem) List(dir string) ([]string, error) {
+	entries, err := os.ReadDir(d.fn(dir))
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if !isTempFile(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (d *filesystem) Walk(dir string, fn func(FileInfo) error) error {
+	root := d.fn(dir)
+	if _, err := os.Stat(root); err != nil {
+		return err
+	}
+	return filepath.WalkDir(root, func(walkFn string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || isTempFile(entry.Name()) {
+			return nil
+		}
+		fileInfo, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(d.root, walkFn)
+		if err != nil {
+			return err
+		}
+		return fn(FileInfo{Path: filepath.ToSlash(rel), Size: fileInfo.Size(), Modified: fileInfo.ModTime()})
+	})
+}
+
+// Removes the temporary files of writes interrupted by a crash
+func (d *filesystem) Recover() error {
+	if _, err := os.Stat(d.root); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	tempFns := []string{}
+	err := filepath.WalkDir(d.root, func(walkFn string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() && isTempFile(entry.Name()) {
+			tempFns = append(tempFns, walkFn)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, fn := range tempFns {
+		err = os.Remove(fn)
+		if err != nil {
+			return err
+		}
+		err = d.deleteEmptyDirs(filepath.Dir(fn))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isTempFile(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, tempSuffix)
+}
+
+// Persists the directory entries, e.g. of a renamed file
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = f.Sync()
+	if err != nil && !errors.Is(err, os.ErrInvalid) {
+		// some platforms cannot sync directories
+		return err
+	}
+	return nil
+}
+
+type filesystemWriter struct {
+	f *os.File
+	// the file a new file is renamed to on Close, empty for an appended file
+	fn string
+	// the size of an appended file before writing, -1 for a new file
+	offset int64
+	size   int64
+}
+
+func (w *filesystemWriter) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *filesystemWriter) Size() int64 {
+	return w.size
+}
+
+func (w *filesystemWriter) Close() error {
+	err := w.f.Sync()
+	if err == nil {
+		err = w.f.Close()
+	}
+	if err == nil && w.offset < 0 {
+		err = os.Rename(w.f.Name(), w.fn)
+		if err == nil {
+			err = syncDir(filepath.Dir(w.fn))
+		}
+	}
+	if err != nil {
+		w.Cancel()
+	}
+	return err
+}
+
+func (w *filesystemWriter) Cancel() error {
+	w.f.Close()
+	if w.offset < 0 {
+		err := os.Remove(w.f.Name())
+		if errors.Is(err, fs.ErrNotExist) {
+			// already renamed
+			return nil
+		}
+		return err
+	}
+	return os.Truncate(w.f.Name(), w.offset)
+}
@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Keeps all files in memory, e.g. for tests and ephemeral CI registries. Everything is lost when the server stops.
+type memory struct {
+	mutex sync.RWMutex
+	files map[string]*memoryFile
+}
+
+type memoryFile struct {
+	data     []byte
+	modified time.Time
+}
+
+func NewMemory() Driver {
+	return &memory{files: map[string]*memoryFile{}}
+}
+
+func (d *memory) Name() string {
+	return DriverMemory
+}
+
+func notExist(op, path string) error {
+	return &fs.PathError{Op: op, Path: path, Err: fs.ErrNotExist}
+}
+
+// Returns the prefix of all files below dir, "" for the root directory
+func dirPrefix(dir string) string {
+	dir = Join(dir)
+	if dir == "" || dir == "." {
+		return ""
+	}
+	return dir + "/"
+}
+
+func (d *memory) Stat(path string) (FileInfo, error) {
+	path = Join(path)
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	if file, ok := d.files[path]; ok {
+		return FileInfo{Path: path, Size: int64(len(file.data)), Modified: file.modified}, nil
+	}
+	prefix := dirPrefix(path)
+	fileInfo := FileInfo{Path: path, IsDir: true}
+	found := false
+	for fn, file := range d.files {
+		if strings.HasPrefix(fn, prefix) {
+			found = true
+			if file.modified.After(fileInfo.Modified) {
+				fileInfo.Modified = file.modified
+			}
+		}
+	}
+	if !found {
+		return FileInfo{}, notExist("stat", path)
+	}
+	return fileInfo, nil
+}
+
+func (d *memory) Reader(path string) (io.ReadSeekCloser, error) {
+	path = Join(path)
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	file, ok := d.files[path]
+	if !ok {
+		return nil, notExist("open", path)
+	}
+	// files are replaced on write, never modified, so readers can share the data
+	return &memoryReader{bytes.NewReader(file.data)}, nil
+}
+
+func (d *memory) Writer(path string, append bool) (FileWriter, error) {
+	path = Join(path)
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	w := &memoryWriter{driver: d, path: path}
+	if append {
+		file, ok := d.files[path]
+		if !ok {
+			return nil, notExist("open", path)
+		}
+		w.buf.Write(file.data)
+	}
+	return w, nil
+}
+
+func (d *memory) Move(src, dst string) error {
+	src = Join(src)
+	dst = Join(dst)
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	file, ok := d.files[src]
+	if !ok {
+		return notExist("rename", src)
+	}
+	delete(d.files, src)
+	d.files[dst] = file
+	return nil
+}
+
+func (d *memory) Delete(path string) error {
+	path = Join(path)
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, ok := d.files[path]; ok {
+		delete(d.files, path)
+		return nil
+	}
+	prefix := dirPrefix(path)
+	found := false
+	for fn := range d.files {
+		if strings.HasPrefix(fn, prefix) {
+			delete(d.files, fn)
+			found = true
+		}
+	}
+	if !found {
+		return notExist("remove", path)
+	}
+	return nil
+}
+
+func (d *memory) List(dir string) ([]string, error) {
+	prefix := dirPrefix(dir)
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	names := map[string]bool{}
+	for fn := range d.files {
+		if strings.HasPrefix(fn, prefix) {
+			name, _, _ := strings.Cut(fn[len(prefix):], "/")
+			names[name] = true
+		}
+	}
+	if len(names) == 0 {
+		return nil, notExist("open", dir)
+	}
+	list := make([]string, 0, len(names))
+	for name := range names {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+func (d *memory) Walk(dir string, fn func(FileInfo) error) error {
+	prefix := dirPrefix(dir)
+	d.mutex.RLock()
+	fileInfos := []FileInfo{}
+	for path, file := range d.files {
+		if strings.HasPrefix(path, prefix) {
+			fileInfos = append(fileInfos, FileInfo{Path: path, Size: int64(len(file.data)), Modified: file.modified})
+		}
+	}
+	d.mutex.RUnlock()
+
+	if len(fileInfos) == 0 {
+		return notExist("open", dir)
+	}
+	sort.Slice(fileInfos, func(i, j int) bool {
+		return fileInfos[i].Path < fileInfos[j].Path
+	})
+	// fn may modify the driver, e.g. delete the file
+	for _, fileInfo := range fileInfos {
+		err := fn(fileInfo)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Files are replaced on Close, there is nothing to recover
+func (d *memory) Recover() error {
+	return nil
+}
+
+type memoryReader struct {
+	*bytes.Reader
+}
+
+func (r *memoryReader) Close() error {
+	return nil
+}
+
+type memoryWriter struct {
+	driver *memory
+	path   string
+	buf    bytes.Buffer
+	done   bool
+}
+
+func (w *memoryWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *memoryWriter) Size() int64 {
+	return int64(w.buf.Len())
+}
+
+func (w *memoryWriter) Close() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	w.driver.mutex.Lock()
+	defer w.driver.mutex.Unlock()
+	w.driver.files[w.path] = &memoryFile{data: w.buf.Bytes(), modified: time.Now()}
+	return nil
+}
+
+func (w *memoryWriter) Cancel() error {
+	w.done = true
+	return nil
+}
@@ -0,0 +1,964 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stores files as objects of an S3 compatible object storage, e.g. AWS S3 or MinIO.
+// The object key of a file is the bucket's key prefix followed by the file's path.
+// Requests are signed with AWS signature version 4 and address the bucket path-style, i.e. endpoint/bucket/key.
+//
+// An appending writer, i.e. a chunk of a blob upload, leaves its multipart upload open, so that the next chunk only adds parts.
+// The object then holds the tail of the file which is too small for a part, its metadata the upload ID and the size of the parts.
+// The state object key#multipart keeps the part ETags. Reading or moving the file completes the multipart upload.
+
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+}
+
+const (
+	// Parts of multipart uploads must have at least 5 MiB, except the last one
+	s3PartSize = 5 * 1024 * 1024
+	// Objects of up to 5 GiB can be copied in a single request, larger ones are copied in parts
+	s3MaxCopySize  = 5 * 1024 * 1024 * 1024
+	s3CopyPartSize = 1024 * 1024 * 1024
+
+	s3MultipartSuffix  = "#multipart"
+	s3UploadIdHeader   = "X-Amz-Meta-Mosi-Upload-Id"
+	s3PartsSizeHeader  = "X-Amz-Meta-Mosi-Parts-Size"
+	s3DialTimeout      = 30 * time.Second
+	s3ResponseTimeout  = 2 * time.Minute
+	s3IdleConnsPerHost = 16
+)
+
+type s3 struct {
+	cfg    S3Config
+	prefix string
+	client *http.Client
+}
+
+func NewS3(cfg S3Config) (Driver, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("the s3 storage driver requires an endpoint and a bucket")
+	}
+	if _, err := url.Parse(cfg.Endpoint); err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint %s: %w", cfg.Endpoint, err)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+	// no overall timeout, reads stream blobs of any size
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: s3DialTimeout, KeepAlive: s3DialTimeout}).DialContext,
+		TLSHandshakeTimeout:   s3DialTimeout,
+		ResponseHeaderTimeout: s3ResponseTimeout,
+		ExpectContinueTimeout: time.Second,
+		MaxIdleConnsPerHost:   s3IdleConnsPerHost,
+		IdleConnTimeout:       s3ResponseTimeout,
+	}
+	return &s3{cfg: cfg, prefix: dirPrefix(cfg.Prefix), client: &http.Client{Transport: transport}}, nil
+}
+
+func (d *s3) Name() string {
+	return DriverS3
+}
+
+func (d *s3) key(path string) string {
+	return d.prefix + Join(path)
+}
+
+// Returns the key prefix of all objects below dir
+func (d *s3) dirKey(dir string) string {
+	return d.prefix + dirPrefix(dir)
+}
+
+func (d *s3) Stat(path string) (FileInfo, error) {
+	path = Join(path)
+	if path != "" && path != "." {
+		rsp, err := d.request(http.MethodHead, d.key(path), nil, nil, nil)
+		if err == nil {
+			return d.fileInfo(path, rsp)
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return FileInfo{}, err
+		}
+	}
+	result, err := d.list(d.dirKey(path), "", "", 1)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if len(result.Contents) == 0 {
+		return FileInfo{}, notExist("stat", path)
+	}
+	return FileInfo{Path: path, IsDir: true}, nil
+}
+
+func (d *s3) fileInfo(path string, rsp *http.Response) (FileInfo, error) {
+	size, err := strconv.ParseInt(rsp.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("s3 object %s has no content length", path)
+	}
+	modified, _ := http.ParseTime(rsp.Header.Get("Last-Modified"))
+	if rsp.Header.Get(s3UploadIdHeader) != "" {
+		partsSize, err := strconv.ParseInt(rsp.Header.Get(s3PartsSizeHeader), 10, 64)
+		if err != nil {
+			return FileInfo{}, fmt.Errorf("s3 object %s has an invalid parts size", path)
+		}
+		size += partsSize
+	}
+	return FileInfo{Path: path, Size: size, Modified: modified}, nil
+}
+
+// The reader streams the object and requests a new range of it after seeking
+func (d *s3) Reader(path string) (io.ReadSeekCloser, error) {
+	err := d.completeMultipart(path)
+	if err != nil {
+		return nil, err
+	}
+	fileInfo, err := d.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fileInfo.IsDir {
+		return nil, fmt.Errorf("s3 object %s is a directory", path)
+	}
+	return &s3Reader{driver: d, key: d.key(path), size: fileInfo.Size}, nil
+}
+
+// Small files are written by a single request, larger ones by a multipart upload while writing.
+// Appending continues the open multipart upload of the file, or turns the existing object into the first parts of one.
+func (d *s3) Writer(path string, append bool) (FileWriter, error) {
+	w := &s3Writer{driver: d, key: d.key(path)}
+	if !append {
+		return w, nil
+	}
+	w.keepOpen = true
+
+	rsp, err := d.request(http.MethodHead, w.key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	fileInfo, err := d.fileInfo(path, rsp)
+	if err != nil {
+		return nil, err
+	}
+	multipart, err := d.loadMultipart(w.key, rsp)
+	if err != nil {
+		return nil, err
+	}
+	if multipart != nil {
+		w.uploadId = multipart.UploadId
+		w.parts = multipart.Parts
+		for _, part := range w.parts {
+			w.size += part.Size
+		}
+	} else if fileInfo.Size >= s3PartSize {
+		err = w.createMultipartUpload()
+		if err != nil {
+			return nil, err
+		}
+		err = w.copyParts(w.key, fileInfo.Size)
+		if err != nil {
+			w.Cancel()
+			return nil, err
+		}
+		w.size = fileInfo.Size
+		return w, nil
+	}
+
+	// the tail is smaller than a part
+	tail, err := d.readObject(w.key)
+	if err != nil {
+		return nil, err
+	}
+	w.buf.Write(tail)
+	w.size += int64(len(tail))
+	return w, nil
+}
+
+func (d *s3) Move(src, dst string) error {
+	fileInfo, err := d.Stat(src)
+	if err != nil {
+		return err
+	}
+	if fileInfo.IsDir {
+		return fmt.Errorf("s3 object %s is a directory", src)
+	}
+	err = d.completeMultipart(src)
+	if err != nil {
+		return err
+	}
+
+	if fileInfo.Size <= s3MaxCopySize {
+		header := http.Header{"X-Amz-Copy-Source": {d.copySource(d.key(src))}}
+		_, err = d.request(http.MethodPut, d.key(dst), nil, header, nil)
+	} else {
+		w := &s3Writer{driver: d, key: d.key(dst)}
+		err = w.createMultipartUpload()
+		if err == nil {
+			err = w.copyParts(d.key(src), fileInfo.Size)
+			if err == nil {
+				err = w.Close()
+			} else {
+				w.Cancel()
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return d.deleteObject(d.key(src))
+}
+
+func (d *s3) Delete(path string) error {
+	path = Join(path)
+	if path != "" && path != "." {
+		rsp, err := d.request(http.MethodHead, d.key(path), nil, nil, nil)
+		if err == nil {
+			if uploadId := rsp.Header.Get(s3UploadIdHeader); uploadId != "" {
+				err = d.abortMultipart(d.key(path), uploadId)
+				if err != nil {
+					return err
+				}
+			}
+			return d.deleteObject(d.key(path))
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	found := false
+	err := d.walk(d.dirKey(path), func(key string, _ FileInfo) error {
+		found = true
+		if strings.HasSuffix(key, s3MultipartSuffix) {
+			multipart, err := d.readMultipart(strings.TrimSuffix(key, s3MultipartSuffix))
+			if err != nil {
+				return err
+			}
+			return d.abortMultipart(strings.TrimSuffix(key, s3MultipartSuffix), multipart.UploadId)
+		}
+		return d.deleteObject(key)
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return notExist("remove", path)
+	}
+	return nil
+}
+
+func (d *s3) deleteObject(key string) error {
+	_, err := d.request(http.MethodDelete, key, nil, nil, nil)
+	return err
+}
+
+func (d *s3) List(dir string) ([]string, error) {
+	prefix := d.dirKey(dir)
+	names := []string{}
+	token := ""
+	for {
+		result, err := d.list(prefix, "/", token, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, content := range result.Contents {
+			if name := strings.TrimPrefix(content.Key, prefix); name != "" && !strings.HasSuffix(name, s3MultipartSuffix) {
+				names = append(names, name)
+			}
+		}
+		for _, commonPrefix := range result.CommonPrefixes {
+			names = append(names, strings.TrimSuffix(strings.TrimPrefix(commonPrefix.Prefix, prefix), "/"))
+		}
+		if !result.IsTruncated {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	if len(names) == 0 {
+		return nil, notExist("open", dir)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (d *s3) Walk(dir string, fn func(FileInfo) error) error {
+	fileInfos := []FileInfo{}
+	err := d.walk(d.dirKey(dir), func(key string, fileInfo FileInfo) error {
+		if !strings.HasSuffix(key, s3MultipartSuffix) {
+			fileInfos = append(fileInfos, fileInfo)
+			return nil
+		}
+		// the listed size of a file with an open multipart upload is the size of its tail
+		path := strings.TrimSuffix(fileInfo.Path, s3MultipartSuffix)
+		for i := range fileInfos {
+			if fileInfos[i].Path == path {
+				stat, err := d.Stat(path)
+				if err != nil {
+					return err
+				}
+				fileInfos[i] = stat
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(fileInfos) == 0 {
+		return notExist("open", dir)
+	}
+	// fn may modify the driver, e.g. delete the file
+	for _, fileInfo := range fileInfos {
+		err := fn(fileInfo)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Calls fn for each object below the key prefix in lexical order
+func (d *s3) walk(prefix string, fn func(key string, fileInfo FileInfo) error) error {
+	token := ""
+	for {
+		result, err := d.list(prefix, "", token, 0)
+		if err != nil {
+			return err
+		}
+		for _, content := range result.Contents {
+			if strings.HasSuffix(content.Key, "/") {
+				// directory marker of other tools
+				continue
+			}
+			path := strings.TrimPrefix(content.Key, d.prefix)
+			err := fn(content.Key, FileInfo{Path: path, Size: content.Size, Modified: content.LastModified})
+			if err != nil {
+				return err
+			}
+		}
+		if !result.IsTruncated {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Aborts incomplete multipart uploads below the key prefix except the open ones of appended files,
+// objects themselves are replaced atomically
+func (d *s3) Recover() error {
+	query := url.Values{"uploads": {""}, "prefix": {d.prefix}}
+	for {
+		rsp, err := d.request(http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return err
+		}
+		result := struct {
+			IsTruncated        bool
+			NextKeyMarker      string
+			NextUploadIdMarker string
+			Upload             []struct {
+				Key      string
+				UploadId string
+			}
+		}{}
+		err = decodeS3Response(rsp, &result)
+		if err != nil {
+			return err
+		}
+		for _, upload := range result.Upload {
+			rsp, err := d.request(http.MethodHead, upload.Key, nil, nil, nil)
+			if err == nil && rsp.Header.Get(s3UploadIdHeader) == upload.UploadId {
+				continue
+			}
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			_, err = d.request(http.MethodDelete, upload.Key, url.Values{"uploadId": {upload.UploadId}}, nil, nil)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+		if !result.IsTruncated {
+			return nil
+		}
+		query.Set("key-marker", result.NextKeyMarker)
+		query.Set("upload-id-marker", result.NextUploadIdMarker)
+	}
+}
+
+// The state of the open multipart upload of an appended file
+type s3Multipart struct {
+	XMLName  xml.Name `xml:"MosiMultipartUpload"`
+	UploadId string   `xml:"UploadId"`
+	Parts    []s3Part `xml:"Part"`
+}
+
+// Returns the open multipart upload of the object of a HEAD response, nil if the object is complete.
+// Parts an interrupted append uploaded after the parts of the object's metadata are dropped.
+func (d *s3) loadMultipart(key string, rsp *http.Response) (*s3Multipart, error) {
+	uploadId := rsp.Header.Get(s3UploadIdHeader)
+	if uploadId == "" {
+		return nil, nil
+	}
+	partsSize, err := strconv.ParseInt(rsp.Header.Get(s3PartsSizeHeader), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("s3 object %s has an invalid parts size", key)
+	}
+	multipart, err := d.readMultipart(key)
+	if err != nil {
+		return nil, err
+	}
+	n := 0
+	var size int64 = 0
+	for n < len(multipart.Parts) && size < partsSize {
+		size += multipart.Parts[n].Size
+		n++
+	}
+	if multipart.UploadId != uploadId || size != partsSize {
+		return nil, fmt.Errorf("s3 object %s does not match its multipart upload %s", key, uploadId)
+	}
+	multipart.Parts = multipart.Parts[:n]
+	return multipart, nil
+}
+
+func (d *s3) readMultipart(key string) (*s3Multipart, error) {
+	rsp, err := d.request(http.MethodGet, key+s3MultipartSuffix, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	multipart := &s3Multipart{}
+	err = decodeS3Response(rsp, multipart)
+	if err != nil {
+		return nil, err
+	}
+	return multipart, nil
+}
+
+// Completes the open multipart upload of an appended file, so that its object holds the whole file
+func (d *s3) completeMultipart(path string) error {
+	key := d.key(path)
+	rsp, err := d.request(http.MethodHead, key, nil, nil, nil)
+	if errors.Is(err, fs.ErrNotExist) {
+		// a directory or missing
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	multipart, err := d.loadMultipart(key, rsp)
+	if err != nil || multipart == nil {
+		return err
+	}
+	tail, err := d.readObject(key)
+	if err != nil {
+		return err
+	}
+	w := &s3Writer{driver: d, key: key, uploadId: multipart.UploadId, parts: multipart.Parts}
+	w.buf.Write(tail)
+	err = w.complete()
+	if err != nil {
+		return err
+	}
+	return d.deleteObject(key + s3MultipartSuffix)
+}
+
+// Aborts a multipart upload and deletes its state
+func (d *s3) abortMultipart(key, uploadId string) error {
+	_, err := d.request(http.MethodDelete, key, url.Values{"uploadId": {uploadId}}, nil, nil)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	err = d.deleteObject(key + s3MultipartSuffix)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Reads an object as it is stored, the tail of an appended file without completing its multipart upload
+func (d *s3) readObject(key string) ([]byte, error) {
+	rsp, err := d.request(http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	return io.ReadAll(rsp.Body)
+}
+
+type s3ListResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+}
+
+// ListObjectsV2, maxKeys 0 returns the default of 1000 keys
+func (d *s3) list(prefix, delimiter, token string, maxKeys int) (*s3ListResult, error) {
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	if delimiter != "" {
+		query.Set("delimiter", delimiter)
+	}
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+	if maxKeys > 0 {
+		query.Set("max-keys", strconv.Itoa(maxKeys))
+	}
+	rsp, err := d.request(http.MethodGet, "", query, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	result := &s3ListResult{}
+	err = decodeS3Response(rsp, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// The x-amz-copy-source header of a copy request
+func (d *s3) copySource(key string) string {
+	return "/" + d.cfg.Bucket + "/" + s3Escape(key, true)
+}
+
+// Sends a request to the bucket, or to an object if key isn't empty. Non-2xx responses are returned as *s3Error.
+// The response body is read and closed unless the request is a GET of an object.
+func (d *s3) request(method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	uri := "/" + s3Escape(d.cfg.Bucket, false)
+	if key != "" {
+		uri += "/" + s3Escape(key, true)
+	}
+	rawQuery := s3CanonicalQuery(query)
+	rawUrl := d.cfg.Endpoint + uri
+	if rawQuery != "" {
+		rawUrl += "?" + rawQuery
+	}
+	req, err := http.NewRequest(method, rawUrl, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	payloadHash := sha256.Sum256(body)
+	d.sign(req, hex.EncodeToString(payloadHash[:]), time.Now())
+
+	rsp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		defer rsp.Body.Close()
+		return nil, newS3Error(method, key, rsp)
+	}
+	if method != http.MethodGet || key == "" {
+		// some errors of copy and complete requests are reported with status 200
+		content, err := io.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Contains(content, []byte("<Error>")) {
+			s3Err := &s3Error{Op: method, Key: key, Status: rsp.StatusCode}
+			xml.Unmarshal(content, s3Err)
+			return nil, s3Err
+		}
+		rsp.Body = io.NopCloser(bytes.NewReader(content))
+	}
+	return rsp, nil
+}
+
+// Signs a request with AWS signature version 4, requests without access key are sent unsigned
+func (d *s3) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if d.cfg.AccessKey == "" {
+		return
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "range" || name == "content-type" || name == "content-md5" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	uri := req.URL.EscapedPath()
+	if uri == "" {
+		uri = "/"
+	}
+	canonicalRequest := strings.Join([]string{req.Method, uri, req.URL.RawQuery, canonicalHeaders, signedHeaders, payloadHash}, "\n")
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	date := amzDate[:8]
+	scope := date + "/" + d.cfg.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalRequestHash[:])
+
+	signingKey := hmacSha256([]byte("AWS4"+d.cfg.SecretKey), date)
+	signingKey = hmacSha256(signingKey, d.cfg.Region)
+	signingKey = hmacSha256(signingKey, "s3")
+	signingKey = hmacSha256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+d.cfg.AccessKey+"/"+scope+",SignedHeaders="+signedHeaders+",Signature="+signature)
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// URI encodes all characters except the unreserved ones, and the slash if keepSlash is true
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || c == '-' || c == '.' || c == '_' || c == '~' || (keepSlash && c == '/') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// The query sorted by name, with names and values URI encoded
+func s3CanonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	params := []string{}
+	for _, name := range names {
+		for _, value := range query[name] {
+			params = append(params, s3Escape(name, false)+"="+s3Escape(value, false))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+func decodeS3Response(rsp *http.Response, v any) error {
+	defer rsp.Body.Close()
+	err := xml.NewDecoder(rsp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("invalid s3 response: %w", err)
+	}
+	return nil
+}
+
+// The error response of an S3 request. Missing objects, i.e. status 404 except for a missing bucket, are fs.ErrNotExist.
+type s3Error struct {
+	Op      string `xml:"-"`
+	Key     string `xml:"-"`
+	Status  int    `xml:"-"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func newS3Error(op, key string, rsp *http.Response) *s3Error {
+	s3Err := &s3Error{Op: op, Key: key, Status: rsp.StatusCode}
+	content, _ := io.ReadAll(rsp.Body)
+	xml.Unmarshal(content, s3Err)
+	if s3Err.Code == "" {
+		s3Err.Code = http.StatusText(rsp.StatusCode)
+	}
+	return s3Err
+}
+
+func (e *s3Error) Error() string {
+	msg := fmt.Sprintf("s3 %s %s: %d %s", e.Op, e.Key, e.Status, e.Code)
+	if e.Message != "" {
+		msg += ", " + e.Message
+	}
+	return msg
+}
+
+func (e *s3Error) Unwrap() error {
+	if e.Status == http.StatusNotFound && e.Code != "NoSuchBucket" {
+		return fs.ErrNotExist
+	}
+	return nil
+}
+
+type s3Reader struct {
+	driver *s3
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (r *s3Reader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		header := http.Header{"Range": {fmt.Sprintf("bytes=%d-", r.offset)}}
+		rsp, err := r.driver.request(http.MethodGet, r.key, nil, header, nil)
+		if err != nil {
+			return 0, err
+		}
+		r.body = rsp.Body
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if errors.Is(err, io.EOF) && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *s3Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("s3 object %s: negative offset", r.key)
+	}
+	if offset != r.offset {
+		r.Close()
+		r.offset = offset
+	}
+	return offset, nil
+}
+
+func (r *s3Reader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
+type s3Writer struct {
+	driver *s3
+	key    string
+	buf    bytes.Buffer
+	size   int64
+	// the multipart upload, empty until the first part is written
+	uploadId string
+	parts    []s3Part
+	// whether the writer created the multipart upload, or continues the open one of an appended file
+	created bool
+	// an appending writer leaves the multipart upload open on Close
+	keepOpen bool
+	done     bool
+}
+
+// A part of a multipart upload, the size is only kept in the state of an open multipart upload
+type s3Part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+	Size       int64  `xml:"Size,omitempty"`
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	n, _ := w.buf.Write(p)
+	w.size += int64(n)
+	for w.buf.Len() >= s3PartSize {
+		err := w.uploadPart(w.buf.Next(s3PartSize))
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (w *s3Writer) Size() int64 {
+	return w.size
+}
+
+func (w *s3Writer) Close() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+
+	if w.uploadId == "" {
+		_, err := w.driver.request(http.MethodPut, w.key, nil, nil, w.buf.Bytes())
+		return err
+	}
+
+	var err error
+	if w.keepOpen {
+		err = w.saveMultipart()
+	} else {
+		err = w.complete()
+	}
+	if err != nil {
+		w.abort()
+	}
+	return err
+}
+
+// Uploads the rest as last part and completes the multipart upload
+func (w *s3Writer) complete() error {
+	if w.buf.Len() > 0 {
+		err := w.uploadPart(w.buf.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+	parts := make([]s3Part, len(w.parts))
+	for i, part := range w.parts {
+		parts[i] = s3Part{PartNumber: part.PartNumber, ETag: part.ETag}
+	}
+	content, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	_, err = w.driver.request(http.MethodPost, w.key, url.Values{"uploadId": {w.uploadId}}, nil, content)
+	return err
+}
+
+// Stores the parts of the open multipart upload, then the rest as the object's tail.
+// The metadata of the tail tells which parts belong to the file if the append is interrupted in between.
+func (w *s3Writer) saveMultipart() error {
+	content, err := xml.Marshal(s3Multipart{UploadId: w.uploadId, Parts: w.parts})
+	if err != nil {
+		return err
+	}
+	_, err = w.driver.request(http.MethodPut, w.key+s3MultipartSuffix, nil, nil, content)
+	if err != nil {
+		return err
+	}
+	var partsSize int64 = 0
+	for _, part := range w.parts {
+		partsSize += part.Size
+	}
+	header := http.Header{s3UploadIdHeader: {w.uploadId}, s3PartsSizeHeader: {strconv.FormatInt(partsSize, 10)}}
+	_, err = w.driver.request(http.MethodPut, w.key, nil, header, w.buf.Bytes())
+	return err
+}
+
+// Aborts the multipart upload the writer created, the object keeps its previous content
+func (w *s3Writer) Cancel() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	return w.abort()
+}
+
+func (w *s3Writer) abort() error {
+	if w.uploadId == "" || !w.created {
+		return nil
+	}
+	_, err := w.driver.request(http.MethodDelete, w.key, url.Values{"uploadId": {w.uploadId}}, nil, nil)
+	return err
+}
+
+func (w *s3Writer) createMultipartUpload() error {
+	rsp, err := w.driver.request(http.MethodPost, w.key, url.Values{"uploads": {""}}, nil, nil)
+	if err != nil {
+		return err
+	}
+	result := struct {
+		UploadId string
+	}{}
+	err = decodeS3Response(rsp, &result)
+	if err != nil {
+		return err
+	}
+	w.uploadId = result.UploadId
+	w.created = true
+	return nil
+}
+
+func (w *s3Writer) uploadPart(data []byte) error {
+	if w.uploadId == "" {
+		err := w.createMultipartUpload()
+		if err != nil {
+			return err
+		}
+	}
+	partNumber := len(w.parts) + 1
+	query := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {w.uploadId}}
+	rsp, err := w.driver.request(http.MethodPut, w.key, query, nil, data)
+	if err != nil {
+		return err
+	}
+	w.parts = append(w.parts, s3Part{PartNumber: partNumber, ETag: rsp.Header.Get("ETag"), Size: int64(len(data))})
+	return nil
+}
+
+// Copies an object of the given size into the next parts, all of them at least s3PartSize large
+func (w *s3Writer) copyParts(srcKey string, size int64) error {
+	for start := int64(0); start < size; {
+		end := start + s3CopyPartSize
+		if end+s3PartSize > size {
+			end = size
+		}
+		partNumber := len(w.parts) + 1
+		query := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {w.uploadId}}
+		header := http.Header{
+			"X-Amz-Copy-Source":       {w.driver.copySource(srcKey)},
+			"X-Amz-Copy-Source-Range": {fmt.Sprintf("bytes=%d-%d", start, end-1)},
+		}
+		rsp, err := w.driver.request(http.MethodPut, w.key, query, header, nil)
+		if err != nil {
+			return err
+		}
+		result := struct {
+			ETag string
+		}{}
+		err = decodeS3Response(rsp, &result)
+		if err != nil {
+			return err
+		}
+		w.parts = append(w.parts, s3Part{PartNumber: partNumber, ETag: result.ETag, Size: end - start})
+		start = end
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// A storage driver stores files under slash-separated paths relative to its root, e.g. "v2/team/app/manifests/latest".
+// Directories are implicit: a directory exists as long as it contains any file and disappears with its last file.
+// Functions return an error wrapping fs.ErrNotExist if a path does not exist.
+
+const (
+	DriverFilesystem = "filesystem"
+	DriverMemory     = "memory"
+	DriverS3         = "s3"
+)
+
+type FileInfo struct {
+	Path     string
+	Size     int64
+	Modified time.Time
+	IsDir    bool
+}
+
+// A FileWriter writes a file, the written data is visible to readers after Close at the latest.
+// A new file replaces an existing one atomically on Close, readers see either the old or the new file.
+// Cancel discards the data written by the writer, an appending writer restores the file's previous content.
+type FileWriter interface {
+	io.Writer
+	Size() int64
+	Close() error
+	Cancel() error
+}
+
+type Driver interface {
+	Name() string
+	Stat(path string) (FileInfo, error)
+	Reader(path string) (io.ReadSeekCloser, error)
+	// Creates or truncates a file, or resumes writing at its end if append is true.
+	// Appending requires the file to exist.
+	Writer(path string, append bool) (FileWriter, error)
+	// Moves a file, replacing an existing file at dst
+	Move(src, dst string) error
+	// Deletes a file or a directory with all its files
+	Delete(path string) error
+	// Returns the names of the files and directories in a directory in lexical order
+	List(dir string) ([]string, error)
+	// Calls fn for each file below dir in lexical order, stops if fn returns an error
+	Walk(dir string, fn func(FileInfo) error) error
+	// Removes the leftovers of writes interrupted by a crash, must not run concurrently with writes
+	Recover() error
+}
+
+// Creates the driver of the given name. The filesystem driver stores files in dir, the s3 driver in the bucket of s3Config.
+func New(name, dir string, s3Config S3Config) (Driver, error) {
+	switch name {
+	case "", DriverFilesystem:
+		return NewFilesystem(dir), nil
+	case DriverMemory:
+		return NewMemory(), nil
+	case DriverS3:
+		return NewS3(s3Config)
+	default:
+		return nil, fmt.Errorf("unknown storage driver %s", name)
+	}
+}
+
+// Joins path elements to a clean slash-separated path without leading slash
+func Join(elem ...string) string {
+	return strings.TrimPrefix(path.Join(elem...), "/")
+}
+
+func ReadBytes(driver Driver, path string) ([]byte, error) {
+	r, err := driver.Reader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func WriteBytes(driver Driver, path string, data []byte) error {
+	w, err := driver.Writer(path, false)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		w.Cancel()
+		return err
+	}
+	return w.Close()
+}
+
+func Exists(driver Driver, path string) bool {
+	_, err := driver.Stat(path)
+	return err == nil
+}
+
+func IsDir(driver Driver, path string) bool {
+	fileInfo, err := driver.Stat(path)
+	return err == nil && fileInfo.IsDir
+}
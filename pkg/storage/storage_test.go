@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testDriver(t *testing.T, driver Driver) {
+	assert := assert.New(t)
+
+	_, err := driver.Stat("v2/app/manifests/latest")
+	assert.ErrorIs(err, fs.ErrNotExist)
+	_, err = driver.List("v2")
+	assert.ErrorIs(err, fs.ErrNotExist)
+
+	assert.Nil(WriteBytes(driver, "v2/app/manifests/latest", []byte("sha256:1234")))
+	assert.Nil(WriteBytes(driver, "v2/app/manifests/1.0", []byte("sha256:5678")))
+	assert.Nil(WriteBytes(driver, "v2/team/app/blobs/sha256-1234", []byte{}))
+
+	data, err := ReadBytes(driver, "v2/app/manifests/latest")
+	assert.Nil(err)
+	assert.Equal("sha256:1234", string(data))
+
+	fileInfo, err := driver.Stat("v2/app/manifests/1.0")
+	assert.Nil(err)
+	assert.Equal(int64(11), fileInfo.Size)
+	assert.False(fileInfo.IsDir)
+	assert.True(IsDir(driver, "v2/app/manifests"))
+
+	names, err := driver.List("v2")
+	assert.Nil(err)
+	assert.Equal([]string{"app", "team"}, names)
+	names, err = driver.List("v2/app/manifests")
+	assert.Nil(err)
+	assert.Equal([]string{"1.0", "latest"}, names)
+
+	paths := []string{}
+	assert.Nil(driver.Walk("v2", func(fileInfo FileInfo) error {
+		paths = append(paths, fileInfo.Path)
+		return nil
+	}))
+	assert.Equal([]string{"v2/app/manifests/1.0", "v2/app/manifests/latest", "v2/team/app/blobs/sha256-1234"}, paths)
+
+	// resumable append
+	w, err := driver.Writer("v2/app/uploads/1", false)
+	assert.Nil(err)
+	assert.Nil(w.Close())
+	w, err = driver.Writer("v2/app/uploads/1", true)
+	assert.Nil(err)
+	w.Write([]byte("0123"))
+	assert.Nil(w.Close())
+	w, err = driver.Writer("v2/app/uploads/1", true)
+	assert.Nil(err)
+	w.Write([]byte("4567"))
+	assert.Equal(int64(8), w.Size())
+	assert.Nil(w.Close())
+	w, err = driver.Writer("v2/app/uploads/1", true)
+	assert.Nil(err)
+	w.Write([]byte("discarded"))
+	assert.Nil(w.Cancel())
+	_, err = driver.Writer("v2/app/uploads/2", true)
+	assert.ErrorIs(err, fs.ErrNotExist)
+
+	r, err := driver.Reader("v2/app/uploads/1")
+	assert.Nil(err)
+	r.Seek(2, io.SeekStart)
+	data, _ = io.ReadAll(r)
+	r.Close()
+	assert.Equal("234567", string(data))
+
+	assert.Nil(driver.Move("v2/app/uploads/1", "blobs/sha256-1234"))
+	assert.False(Exists(driver, "v2/app/uploads"))
+	data, _ = ReadBytes(driver, "blobs/sha256-1234")
+	assert.Equal("01234567", string(data))
+
+	assert.Nil(driver.Delete("v2/app/manifests/latest"))
+	assert.ErrorIs(driver.Delete("v2/app/manifests/latest"), fs.ErrNotExist)
+	assert.Nil(driver.Delete("v2/app"))
+	assert.False(Exists(driver, "v2/app/manifests/1.0"))
+	names, _ = driver.List("v2")
+	assert.Equal([]string{"team"}, names)
+}
+
+func TestFilesystem(t *testing.T) {
+	testDriver(t, NewFilesystem(t.TempDir()))
+}
+
+func TestFilesystemRecover(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	driver := NewFilesystem(dir)
+	assert.Nil(WriteBytes(driver, "v2/app/manifests/latest", []byte("sha256:1234")))
+
+	// a crash while replacing the tag leaves the temporary file of the new tag behind
+	w, err := driver.Writer("v2/app/manifests/latest", false)
+	assert.Nil(err)
+	w.Write([]byte("sha256:5678"))
+	w, err = driver.Writer("v2/app/manifests/1.0", false)
+	assert.Nil(err)
+	w.Write([]byte("sha256:5678"))
+
+	names, err := driver.List("v2/app/manifests")
+	assert.Nil(err)
+	assert.Equal([]string{"latest"}, names)
+	data, _ := ReadBytes(driver, "v2/app/manifests/latest")
+	assert.Equal("sha256:1234", string(data))
+
+	assert.Nil(driver.Recover())
+	entries, err := os.ReadDir(filepath.Join(dir, "v2", "app", "manifests"))
+	assert.Nil(err)
+	assert.Len(entries, 1)
+	data, _ = ReadBytes(driver, "v2/app/manifests/latest")
+	assert.Equal("sha256:1234", string(data))
+}
+
+func TestMemory(t *testing.T) {
+	testDriver(t, NewMemory())
+}
+
+func TestNew(t *testing.T) {
+	assert := assert.New(t)
+
+	driver, err := New("", t.TempDir(), S3Config{})
+	assert.Nil(err)
+	assert.Equal(DriverFilesystem, driver.Name())
+	driver, err = New(DriverMemory, "", S3Config{})
+	assert.Nil(err)
+	assert.Equal(DriverMemory, driver.Name())
+	_, err = New("floppy", "", S3Config{})
+	assert.NotNil(err)
+}